@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+// oidSignedData is the PKCS#7 signedData content type that carries .p7b bundles.
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// parseCertificates returns every certificate in data, which may be a PEM
+// file with any number of CERTIFICATE or PKCS7 blocks, DER certificates, or
+// a DER PKCS#7 bundle. Other PEM blocks, such as private keys, are skipped.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		return parsePEMCertificates(data)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	certs, err := parsePKCS7(data)
+	if err != nil {
+		return nil, fmt.Errorf("not a PEM, DER or PKCS#7 certificate file")
+	}
+	return certs, nil
+}
+
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for n := 1; ; n++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE", "TRUSTED CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("PEM block %d: %v", n, err)
+			}
+			certs = append(certs, cert)
+		case "PKCS7":
+			bundle, err := parsePKCS7(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("PEM block %d: %v", n, err)
+			}
+			certs = append(certs, bundle...)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate PEM blocks found")
+	}
+	return certs, nil
+}
+
+// parsePKCS7 returns the certificates of a DER PKCS#7 signedData bundle.
+func parsePKCS7(der []byte) ([]*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("cannot parse PKCS#7: %v", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("PKCS#7 content type %v is not signedData", info.ContentType)
+	}
+	var signed pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("cannot parse PKCS#7 signedData: %v", err)
+	}
+	certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse PKCS#7 certificates: %v", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("PKCS#7 bundle holds no certificates")
+	}
+	return certs, nil
+}
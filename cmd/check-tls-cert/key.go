@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/pkcs12"
+)
+
+// parsePrivateKey decodes an RSA, ECDSA or Ed25519 private key in PKCS#1,
+// PKCS#8 or SEC 1 form, PEM or DER. Encrypted PKCS#8 keys and legacy
+// encrypted PEM blocks are decrypted with password.
+func parsePrivateKey(data []byte, password string) (crypto.Signer, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		for ; block != nil; block, data = pem.Decode(data) {
+			if block.Type == "PRIVATE KEY" || block.Type == "ENCRYPTED PRIVATE KEY" || block.Type == "RSA PRIVATE KEY" || block.Type == "EC PRIVATE KEY" {
+				break
+			}
+		}
+		if block == nil {
+			return nil, fmt.Errorf("no private key PEM block found")
+		}
+		der = block.Bytes
+		//nolint:staticcheck // legacy "Proc-Type: 4,ENCRYPTED" keys are still written by older tooling
+		if x509.IsEncryptedPEMBlock(block) {
+			if len(password) == 0 {
+				return nil, fmt.Errorf("private key is encrypted, --key-pass is required")
+			}
+			var err error
+			//nolint:staticcheck
+			if der, err = x509.DecryptPEMBlock(block, []byte(password)); err != nil {
+				return nil, fmt.Errorf("cannot decrypt private key: %v", err)
+			}
+		}
+	}
+
+	if pkcs12.IsEncryptedKey(der) {
+		if len(password) == 0 {
+			return nil, fmt.Errorf("private key is encrypted, --key-pass is required")
+		}
+		var err error
+		if der, err = pkcs12.DecryptKey(der, password); errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, errors.New("cannot decrypt private key: wrong --key-pass or corrupted key")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var key any
+	var err error
+	if key, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(der); err != nil {
+			if key, err = x509.ParseECPrivateKey(der); err != nil {
+				return nil, fmt.Errorf("not a PKCS#1, PKCS#8 or SEC 1 private key")
+			}
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// keyMatches reports whether key is the private half of cert's public key.
+func keyMatches(cert *x509.Certificate, key crypto.PrivateKey) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && public.Equal(cert.PublicKey)
+}
+
+// checkKeyMatch reports whether key belongs to cert. A mismatch is critical.
+func checkKeyMatch(cert *x509.Certificate, key crypto.PrivateKey, keySource string) (int, string) {
+	if !keyMatches(cert, key) {
+		return sensu.CheckStateCritical, fmt.Sprintf("critical: private key %v does not match cert %q", keySource, cert.Subject.String())
+	}
+	return sensu.CheckStateOK, fmt.Sprintf("ok: private key %v matches cert %q", keySource, cert.Subject.String())
+}
+
+// checkKeyFile loads --key and checks it against cert.
+func checkKeyFile(cert *x509.Certificate) (int, string, error) {
+	data, err := os.ReadFile(plugin.KeyFile)
+	if err != nil {
+		return sensu.CheckStateCritical, "", fmt.Errorf("cannot read private key file: %v", err)
+	}
+	key, err := parsePrivateKey(data, plugin.KeyPass)
+	if err != nil {
+		return sensu.CheckStateCritical, "", fmt.Errorf("cannot parse private key %v: %v", plugin.KeyFile, err)
+	}
+	state, line := checkKeyMatch(cert, key, plugin.KeyFile)
+	return state, line, nil
+}
@@ -3,34 +3,58 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 
-	"golang.org/x/crypto/pkcs12"
-
 	"github.com/go-playground/validator/v10"
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/certpolicy"
+	"github.com/nmollerup/sensu-check-tls/internal/certstate"
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+	"github.com/nmollerup/sensu-check-tls/internal/pins"
+	"github.com/nmollerup/sensu-check-tls/internal/pkcs12"
 )
 
 // Config represents the check plugin config.
 type Config struct {
 	sensu.PluginConfig
-	Host               string
-	IP                 string
-	ServerName         string
-	TrustedCAFile      string
-	PemFile            string
-	PKCS12File         string
-	PKCS12Pass         string
-	InsecureSkipVerify bool
-	Port               int
-	Timeout            int
-	Warning            int
-	Critical           int
+	Host                string
+	IP                  string
+	ServerName          string
+	TrustedCAFile       string
+	PemFile             string
+	PKCS12File          string
+	PKCS12Pass          string
+	Paths               []string
+	ScanInclude         []string
+	ScanExclude         []string
+	Recursive           bool
+	UnreadableState     string
+	InsecureSkipVerify  bool
+	ChainExpiry         bool
+	VerifiedPathExpiry  bool
+	LeafOnly            bool
+	KeyFile             string
+	KeyPass             string
+	Port                int
+	Timeout             int
+	Warning             string
+	Critical            string
+	NotBeforeGrace      string
+	MinRSABits          int
+	ECDSACurves         []string
+	AllowWeakSignatures bool
+	MaxValidity         string
+	VerifiedPathPolicy  bool
+	PinCert             []string
+	PinSPKI             []string
+	StateFile           string
+	ChangeState         string
 }
 
 var (
@@ -49,7 +73,7 @@ var (
 			Path:     "hostname",
 			Argument: "hostname",
 			Default:  "",
-			Usage:    "Hostname to check (required unless --pem or --pkcs12 is set)",
+			Usage:    "Hostname to check (required unless --pem, --pkcs12 or --path is set)",
 			Value:    &plugin.Host,
 		},
 		&sensu.PluginConfigOption[string]{
@@ -75,6 +99,20 @@ var (
 			Usage:     "Skip TLS certificate verification (not recommended)",
 			Value:     &plugin.InsecureSkipVerify,
 		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "chain-expiry",
+			Argument: "chain-expiry",
+			Default:  false,
+			Usage:    "Apply expiry thresholds to every certificate presented by the server, not just the leaf",
+			Value:    &plugin.ChainExpiry,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "verified-path-expiry",
+			Argument: "verified-path-expiry",
+			Default:  false,
+			Usage:    "Like --chain-expiry, but also include the trusted root of the verified chain",
+			Value:    &plugin.VerifiedPathExpiry,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "trusted-ca-file",
 			Argument:  "trusted-ca-file",
@@ -88,9 +126,30 @@ var (
 			Argument:  "pem",
 			Shorthand: "P",
 			Default:   "",
-			Usage:     "Path to PEM certificate file to check (no network connection needed)",
+			Usage:     "Path to a PEM, DER or PKCS#7 certificate file to check, checking every certificate in it (no network connection needed)",
 			Value:     &plugin.PemFile,
 		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "leaf-only",
+			Argument: "leaf-only",
+			Default:  false,
+			Usage:    "With --pem, apply the expiry thresholds only to the first certificate in the file",
+			Value:    &plugin.LeafOnly,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "key",
+			Argument: "key",
+			Default:  "",
+			Usage:    "With --pem, private key file (PKCS#1, PKCS#8 or SEC 1, PEM or DER) that must match the certificate",
+			Value:    &plugin.KeyFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "key-pass",
+			Argument: "key-pass",
+			Default:  "",
+			Usage:    "Passphrase for an encrypted --key",
+			Value:    &plugin.KeyPass,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "pkcs12",
 			Argument:  "pkcs12",
@@ -104,23 +163,128 @@ var (
 			Argument:  "pass",
 			Shorthand: "S",
 			Default:   "",
-			Usage:     "Passphrase for PKCS#12 certificate private key",
+			Usage:     "Passphrase for PKCS#12 certificate private key (also used for PKCS#12 files found by --path)",
 			Value:     &plugin.PKCS12Pass,
 		},
-		&sensu.PluginConfigOption[int]{
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "path",
+			Argument: "path",
+			Default:  []string{},
+			Usage:    "Directory, file or glob pattern of certificate files to check (can be repeated; no network connection needed)",
+			Value:    &plugin.Paths,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "recursive",
+			Argument: "recursive",
+			Default:  false,
+			Usage:    "Walk directories given with --path recursively",
+			Value:    &plugin.Recursive,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "include",
+			Argument: "include",
+			Default:  defaultScanInclude,
+			Usage:    "Only check files in --path directories whose name matches one of these glob patterns",
+			Value:    &plugin.ScanInclude,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "exclude",
+			Argument: "exclude",
+			Default:  []string{},
+			Usage:    "Skip files found by --path whose name matches one of these glob patterns",
+			Value:    &plugin.ScanExclude,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "unreadable-state",
+			Argument: "unreadable-state",
+			Default:  "warning",
+			Usage:    "State for files found by --path that cannot be read or parsed: ok, warning, critical or unknown",
+			Value:    &plugin.UnreadableState,
+		},
+		&sensu.PluginConfigOption[string]{
 			Path:      "",
 			Argument:  "warning",
 			Shorthand: "w",
-			Usage:     "Number of days before expiry to warn",
+			Usage:     "Expiry threshold to warn: days, a duration such as 36h, or a percentage of the validity period such as 20%",
 			Value:     &plugin.Warning,
 		},
-		&sensu.PluginConfigOption[int]{
+		&sensu.PluginConfigOption[string]{
 			Path:      "",
 			Argument:  "critical",
 			Shorthand: "c",
-			Usage:     "Number of days before expiry to go critical",
+			Usage:     "Expiry threshold to go critical: days, a duration such as 36h, or a percentage of the validity period such as 10%",
 			Value:     &plugin.Critical,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "not-before-grace",
+			Argument: "not-before-grace",
+			Default:  "0s",
+			Usage:    "How far in the future a certificate's NotBefore may be before it is critical, as a duration such as 5m",
+			Value:    &plugin.NotBeforeGrace,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "min-rsa-bits",
+			Argument: "min-rsa-bits",
+			Default:  2048,
+			Usage:    "Minimum RSA key size in bits for every certificate checked (0 disables)",
+			Value:    &plugin.MinRSABits,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "ecdsa-curves",
+			Argument: "ecdsa-curves",
+			Default:  []string{"P-256", "P-384", "P-521"},
+			Usage:    "ECDSA curves allowed for certificate keys",
+			Value:    &plugin.ECDSACurves,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "allow-weak-signatures",
+			Argument: "allow-weak-signatures",
+			Default:  false,
+			Usage:    "Accept SHA-1 and MD5 certificate signatures (always accepted on self-signed roots)",
+			Value:    &plugin.AllowWeakSignatures,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "max-validity",
+			Argument: "max-validity",
+			Default:  "",
+			Usage:    "Maximum validity period of end-entity certificates, in days or as a duration (e.g. 398)",
+			Value:    &plugin.MaxValidity,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "verified-path-policy",
+			Argument: "verified-path-policy",
+			Default:  false,
+			Usage:    "Also apply the crypto policy to the trusted root of the verified path, not just the served chain",
+			Value:    &plugin.VerifiedPathPolicy,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "pin-cert",
+			Argument: "pin-cert",
+			Default:  []string{},
+			Usage:    "Expected SHA-256 fingerprint of the leaf or a chain certificate, in hex or base64 (can be repeated)",
+			Value:    &plugin.PinCert,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "pin-spki",
+			Argument: "pin-spki",
+			Default:  []string{},
+			Usage:    "Expected SHA-256 hash of the SubjectPublicKeyInfo of the leaf or a chain certificate, as sha256/base64 or hex (can be repeated)",
+			Value:    &plugin.PinSPKI,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "state-file",
+			Argument: "state-file",
+			Default:  "",
+			Usage:    "Path to a file recording the leaf certificate seen per host, file or --path file; a change since the last run is reported",
+			Value:    &plugin.StateFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "change-state",
+			Argument: "change-state",
+			Default:  "warning",
+			Usage:    "State for a leaf certificate change detected with --state-file: ok, warning or critical",
+			Value:    &plugin.ChangeState,
+		},
 		&sensu.PluginConfigOption[int]{
 			Path:      "",
 			Argument:  "port",
@@ -149,18 +313,46 @@ func main() {
 }
 
 func checkArgs(event *corev2.Event) (int, error) {
-	if plugin.Critical <= 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--critical is required")
-	}
-	if plugin.Warning <= 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--warning is required")
+	expiryPolicy, err := expiry.NewPolicy(plugin.Warning, plugin.Critical, plugin.NotBeforeGrace)
+	if err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	if plugin.Warning <= plugin.Critical {
+	if !expiryPolicy.Critical.Less(expiryPolicy.Warning) {
 		return sensu.CheckStateWarning, fmt.Errorf("--warning must be greater than --critical")
 	}
 
+	if _, err := certpolicy.New(plugin.MinRSABits, plugin.ECDSACurves, plugin.AllowWeakSignatures, plugin.MaxValidity); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+
+	if _, err := pins.ParseAll("--pin-cert", plugin.PinCert); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if _, err := pins.ParseAll("--pin-spki", plugin.PinSPKI); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if (len(plugin.PinCert) > 0 || len(plugin.PinSPKI) > 0) && len(plugin.Paths) > 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--pin-cert and --pin-spki cannot be used with --path")
+	}
+
+	if _, ok := certstate.ChangeStates[plugin.ChangeState]; len(plugin.StateFile) > 0 && !ok {
+		return sensu.CheckStateWarning, fmt.Errorf("--change-state must be one of: ok, warning, critical")
+	}
+	if _, ok := scanStates[plugin.UnreadableState]; len(plugin.Paths) > 0 && !ok {
+		return sensu.CheckStateWarning, fmt.Errorf("--unreadable-state must be one of: ok, warning, critical, unknown")
+	}
+	for _, pattern := range append(append([]string{}, plugin.ScanInclude...), plugin.ScanExclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("%q is not a valid --include/--exclude pattern", pattern)
+		}
+	}
+
+	if len(plugin.KeyFile) > 0 && len(plugin.PemFile) == 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--key requires --pem")
+	}
+
 	// File-based modes skip network validation
-	if len(plugin.PemFile) > 0 || len(plugin.PKCS12File) > 0 {
+	if len(plugin.PemFile) > 0 || len(plugin.PKCS12File) > 0 || len(plugin.Paths) > 0 {
 		if len(plugin.PKCS12File) > 0 && len(plugin.PKCS12Pass) == 0 {
 			return sensu.CheckStateWarning, fmt.Errorf("--pass is required with --pkcs12")
 		}
@@ -169,9 +361,9 @@ func checkArgs(event *corev2.Event) (int, error) {
 
 	// Network mode
 	if len(plugin.Host) == 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--hostname is required (or use --pem / --pkcs12)")
+		return sensu.CheckStateWarning, fmt.Errorf("--hostname is required (or use --pem / --pkcs12 / --path)")
 	}
-	err := validate.Var(plugin.Host, "fqdn")
+	err = validate.Var(plugin.Host, "fqdn")
 	if err != nil {
 		err = validate.Var(plugin.Host, "ip")
 		if err != nil {
@@ -190,6 +382,12 @@ func checkArgs(event *corev2.Event) (int, error) {
 		}
 		tlsConfig.RootCAs = caCertPool
 	}
+	if plugin.InsecureSkipVerify && plugin.VerifiedPathExpiry {
+		return sensu.CheckStateWarning, fmt.Errorf("--verified-path-expiry cannot be used with --insecure-skip-verify")
+	}
+	if plugin.InsecureSkipVerify && plugin.VerifiedPathPolicy {
+		return sensu.CheckStateWarning, fmt.Errorf("--verified-path-policy cannot be used with --insecure-skip-verify")
+	}
 	tlsConfig.InsecureSkipVerify = plugin.InsecureSkipVerify
 	sni := plugin.ServerName
 	if sni == "" {
@@ -200,45 +398,121 @@ func checkArgs(event *corev2.Event) (int, error) {
 	return sensu.CheckStateOK, nil
 }
 
-func parsePemCert(data []byte) (*x509.Certificate, error) {
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return nil, fmt.Errorf("no PEM block found")
-	}
-	return x509.ParseCertificate(block.Bytes)
+// checkExpiry applies the expiry thresholds to cert and returns the state and
+// the headline describing it.
+func checkExpiry(expiryPolicy expiry.Policy, cert *x509.Certificate, source string) (int, string, []string) {
+	timeNow := time.Now()
+	state, _ := expiryPolicy.State(cert, timeNow)
+	return state, fmt.Sprintf("cert %v %v", source, expiry.DescribeValidity(cert, timeNow)), nil
 }
 
-func checkExpiry(cert *x509.Certificate, source string) (int, error) {
+// checkCertFile applies the expiry thresholds to every certificate read from
+// a file, or only to the first one with --leaf-only. Each certificate is
+// listed with its subject and days left either way.
+func checkCertFile(expiryPolicy expiry.Policy, certs []*x509.Certificate, source string) (int, string, []string) {
+	if !plugin.LeafOnly {
+		return checkChainExpiry(expiryPolicy, certs, nil, source)
+	}
 	timeNow := time.Now()
-	expiresInDays := int(cert.NotAfter.Sub(timeNow).Hours() / 24)
+	state, _ := expiryPolicy.State(certs[0], timeNow)
+	headline := fmt.Sprintf("cert %v %q %v", source, certs[0].Subject.String(), expiry.DescribeValidity(certs[0], timeNow))
+	var lines []string
+	for i, cert := range certs[1:] {
+		lines = append(lines, fmt.Sprintf("not checked: %q (position %d) %v", cert.Subject.String(), i+1, expiry.DescribeValidity(cert, timeNow)))
+	}
+	return state, headline, lines
+}
 
-	if expiresInDays < 0 {
-		fmt.Printf("critical: cert %v expired %v days ago\n", source, -expiresInDays)
-		return sensu.CheckStateCritical, nil
+// checkChainExpiry applies the expiry thresholds to every certificate the
+// server presented, plus any certificates only found in the verified path,
+// and reports the one closest to expiry. The state is the worst in the chain.
+func checkChainExpiry(expiryPolicy expiry.Policy, chain, pathOnly []*x509.Certificate, source string) (int, string, []string) {
+	report := expiryPolicy.CheckChain(chain, pathOnly, time.Now())
+	return report.State, fmt.Sprintf("cert chain %v has %v", source, report.Summary), report.Lines
+}
+
+// checkFindings applies the crypto policy to checked, and the pins and the
+// state file to certs when set, and returns the worst state with a line for
+// each finding. pathOnly are the certificates of the verified path that were
+// not presented, which pins may match too.
+func checkFindings(cryptoPolicy certpolicy.Policy, checked, certs, pathOnly []*x509.Certificate, source string) (int, []string, error) {
+	state, lines := cryptoPolicy.Check(checked)
+	if len(plugin.PinCert) > 0 || len(plugin.PinSPKI) > 0 {
+		pinState, pinLines, err := pins.Check(certs, pathOnly, plugin.PinCert, plugin.PinSPKI, source)
+		if err != nil {
+			return pinState, nil, err
+		}
+		if pinState > state {
+			state = pinState
+		}
+		lines = append(lines, pinLines...)
 	}
-	if timeNow.AddDate(0, 0, plugin.Critical).After(cert.NotAfter) {
-		fmt.Printf("critical: cert %v expires in %v days\n", source, expiresInDays)
-		return sensu.CheckStateCritical, nil
+	if len(plugin.StateFile) > 0 {
+		changeState, changeLines := certstate.Check(plugin.StateFile, plugin.ChangeState, source, certs[0])
+		if changeState > state {
+			state = changeState
+		}
+		lines = append(lines, changeLines...)
 	}
-	if timeNow.AddDate(0, 0, plugin.Warning).After(cert.NotAfter) {
-		fmt.Printf("warning: cert %v expires in %v days\n", source, expiresInDays)
-		return sensu.CheckStateWarning, nil
+	return state, lines, nil
+}
+
+// printResult prints the headline led by the final state, followed by the
+// lines explaining it.
+func printResult(state int, headline string, lines []string) {
+	fmt.Printf("%v: %v\n", expiry.StateLabel(state), headline)
+	for _, line := range lines {
+		fmt.Println(line)
 	}
-	fmt.Printf("ok: cert %v expires in %v days\n", source, expiresInDays)
-	return sensu.CheckStateOK, nil
 }
 
 func executeCheck(event *corev2.Event) (int, error) {
+	expiryPolicy, err := expiry.NewPolicy(plugin.Warning, plugin.Critical, plugin.NotBeforeGrace)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	cryptoPolicy, err := certpolicy.New(plugin.MinRSABits, plugin.ECDSACurves, plugin.AllowWeakSignatures, plugin.MaxValidity)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if len(plugin.Paths) > 0 {
+		return checkPaths(expiryPolicy, cryptoPolicy)
+	}
+
 	if len(plugin.PemFile) > 0 {
 		data, err := os.ReadFile(plugin.PemFile)
 		if err != nil {
-			return sensu.CheckStateCritical, fmt.Errorf("cannot read PEM file: %v", err)
+			return sensu.CheckStateCritical, fmt.Errorf("cannot read certificate file: %v", err)
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("cannot parse certificate file %v: %v", plugin.PemFile, err)
+		}
+		state, headline, lines := checkCertFile(expiryPolicy, certs, plugin.PemFile)
+		checked := certs
+		if plugin.LeafOnly {
+			checked = certs[:1]
 		}
-		cert, err := parsePemCert(data)
+		findingState, findings, err := checkFindings(cryptoPolicy, checked, certs, nil, plugin.PemFile)
 		if err != nil {
-			return sensu.CheckStateCritical, fmt.Errorf("cannot parse PEM certificate: %v", err)
+			return findingState, err
 		}
-		return checkExpiry(cert, plugin.PemFile)
+		if findingState > state {
+			state = findingState
+		}
+		lines = append(lines, findings...)
+		if len(plugin.KeyFile) > 0 {
+			keyState, keyLine, err := checkKeyFile(certs[0])
+			if err != nil {
+				return keyState, err
+			}
+			if keyState > state {
+				state = keyState
+			}
+			lines = append(lines, keyLine)
+		}
+		printResult(state, headline, lines)
+		return state, nil
 	}
 
 	if len(plugin.PKCS12File) > 0 {
@@ -246,11 +520,26 @@ func executeCheck(event *corev2.Event) (int, error) {
 		if err != nil {
 			return sensu.CheckStateCritical, fmt.Errorf("cannot read PKCS#12 file: %v", err)
 		}
-		_, cert, err := pkcs12.Decode(data, plugin.PKCS12Pass)
+		key, cert, err := pkcs12.DecodeLeaf(data, plugin.PKCS12Pass)
 		if err != nil {
 			return sensu.CheckStateCritical, fmt.Errorf("cannot parse PKCS#12 file: %v", err)
 		}
-		return checkExpiry(cert, plugin.PKCS12File)
+		state, headline, lines := checkExpiry(expiryPolicy, cert, plugin.PKCS12File)
+		certs := []*x509.Certificate{cert}
+		findingState, findings, err := checkFindings(cryptoPolicy, certs, certs, nil, plugin.PKCS12File)
+		if err != nil {
+			return findingState, err
+		}
+		if findingState > state {
+			state = findingState
+		}
+		lines = append(lines, findings...)
+		keyState, keyLine := checkKeyMatch(cert, key, "in "+plugin.PKCS12File)
+		if keyState > state {
+			state = keyState
+		}
+		printResult(state, headline, append(lines, keyLine))
+		return state, nil
 	}
 
 	// Network mode
@@ -268,6 +557,35 @@ func executeCheck(event *corev2.Event) (int, error) {
 	}
 	defer func() { _ = conn.Close() }()
 
-	cert := conn.ConnectionState().PeerCertificates[0]
-	return checkExpiry(cert, fmt.Sprintf("%v:%v", plugin.Host, plugin.Port))
+	connState := conn.ConnectionState()
+	chain := connState.PeerCertificates
+	var pathOnly []*x509.Certificate
+	if len(connState.VerifiedChains) > 0 {
+		pathOnly = expiry.PathOnly(chain, connState.VerifiedChains[0])
+	}
+	source := fmt.Sprintf("%v:%v", plugin.Host, plugin.Port)
+	var state int
+	var headline string
+	var lines []string
+	switch {
+	case plugin.VerifiedPathExpiry:
+		state, headline, lines = checkChainExpiry(expiryPolicy, chain, pathOnly, source)
+	case plugin.ChainExpiry:
+		state, headline, lines = checkChainExpiry(expiryPolicy, chain, nil, source)
+	default:
+		state, headline, lines = checkExpiry(expiryPolicy, chain[0], source)
+	}
+	policyChecked := chain
+	if plugin.VerifiedPathPolicy {
+		policyChecked = append(append([]*x509.Certificate{}, chain...), pathOnly...)
+	}
+	findingState, findings, err := checkFindings(cryptoPolicy, policyChecked, chain, pathOnly, source)
+	if err != nil {
+		return findingState, err
+	}
+	if findingState > state {
+		state = findingState
+	}
+	printResult(state, headline, append(lines, findings...))
+	return state, nil
 }
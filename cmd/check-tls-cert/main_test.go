@@ -1,15 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/pbkdf2"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
+	"io"
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -17,6 +31,9 @@ import (
 	"github.com/go-playground/validator/v10"
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+	"github.com/nmollerup/sensu-check-tls/internal/pins"
 )
 
 // TestCheckArgs tests the argument validation logic
@@ -35,8 +52,8 @@ func TestCheckArgs(t *testing.T) {
 			name: "missing hostname",
 			config: Config{
 				Host:     "",
-				Warning:  30,
-				Critical: 7,
+				Warning:  "30",
+				Critical: "7",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
@@ -46,63 +63,89 @@ func TestCheckArgs(t *testing.T) {
 			name: "invalid FQDN",
 			config: Config{
 				Host:     "not a valid fqdn!",
-				Warning:  30,
-				Critical: 7,
+				Warning:  "30",
+				Critical: "7",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "hostname is not a valid FQDN",
 		},
 		{
-			name: "missing critical threshold",
+			name: "zero critical threshold",
 			config: Config{
 				Host:     "example.com",
-				Warning:  30,
-				Critical: 0,
+				Warning:  "30",
+				Critical: "0",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
-			errContains: "--critical is required",
+			errContains: "--critical must be greater than 0",
 		},
 		{
 			name: "negative critical threshold",
 			config: Config{
 				Host:     "example.com",
-				Warning:  30,
-				Critical: -1,
+				Warning:  "30",
+				Critical: "-1",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
-			errContains: "--critical is required",
+			errContains: "--critical must be greater than 0",
 		},
 		{
-			name: "missing warning threshold",
+			name: "zero warning threshold",
 			config: Config{
 				Host:     "example.com",
-				Warning:  0,
-				Critical: 7,
+				Warning:  "0",
+				Critical: "7",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
-			errContains: "--warning is required",
+			errContains: "--warning must be greater than 0",
 		},
 		{
 			name: "negative warning threshold",
 			config: Config{
 				Host:     "example.com",
-				Warning:  -1,
-				Critical: 7,
+				Warning:  "-1",
+				Critical: "7",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--warning must be greater than 0",
+		},
+		{
+			name: "verified path expiry with insecure skip verify",
+			config: Config{
+				Host:               "example.com",
+				Warning:            "30",
+				Critical:           "7",
+				InsecureSkipVerify: true,
+				VerifiedPathExpiry: true,
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--verified-path-expiry cannot be used with --insecure-skip-verify",
+		},
+		{
+			name: "verified path policy with insecure skip verify",
+			config: Config{
+				Host:               "example.com",
+				Warning:            "30",
+				Critical:           "7",
+				InsecureSkipVerify: true,
+				VerifiedPathPolicy: true,
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
-			errContains: "--warning is required",
+			errContains: "--verified-path-policy cannot be used with --insecure-skip-verify",
 		},
 		{
 			name: "warning less than critical",
 			config: Config{
 				Host:     "example.com",
-				Warning:  7,
-				Critical: 30,
+				Warning:  "7",
+				Critical: "30",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
@@ -112,19 +155,61 @@ func TestCheckArgs(t *testing.T) {
 			name: "warning equal to critical",
 			config: Config{
 				Host:     "example.com",
-				Warning:  7,
-				Critical: 7,
+				Warning:  "7",
+				Critical: "7",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--warning must be greater than --critical",
 		},
+		{
+			name: "invalid threshold",
+			config: Config{
+				Host:     "example.com",
+				Warning:  "soon",
+				Critical: "7",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--warning: \"soon\" is not a number of days",
+		},
+		{
+			name: "warning percentage less than critical",
+			config: Config{
+				Host:     "example.com",
+				Warning:  "10%",
+				Critical: "20%",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--warning must be greater than --critical",
+		},
+		{
+			name: "duration thresholds",
+			config: Config{
+				Host:     "example.com",
+				Warning:  "36h",
+				Critical: "12h",
+			},
+			wantStatus: sensu.CheckStateOK,
+		},
+		{
+			name: "mixed percentage and days",
+			config: Config{
+				Host:     "example.com",
+				Warning:  "20%",
+				Critical: "3",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--warning and --critical must both be percentages or both be days or durations",
+		},
 		{
 			name: "valid configuration without CA file",
 			config: Config{
 				Host:     "example.com",
-				Warning:  30,
-				Critical: 7,
+				Warning:  "30",
+				Critical: "7",
 			},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
@@ -133,8 +218,8 @@ func TestCheckArgs(t *testing.T) {
 			name: "valid configuration with insecure skip verify",
 			config: Config{
 				Host:               "example.com",
-				Warning:            30,
-				Critical:           7,
+				Warning:            "30",
+				Critical:           "7",
 				InsecureSkipVerify: true,
 			},
 			wantStatus: sensu.CheckStateOK,
@@ -144,8 +229,8 @@ func TestCheckArgs(t *testing.T) {
 			name: "invalid CA file path",
 			config: Config{
 				Host:          "example.com",
-				Warning:       30,
-				Critical:      7,
+				Warning:       "30",
+				Critical:      "7",
 				TrustedCAFile: "/nonexistent/ca.pem",
 			},
 			wantStatus:  sensu.CheckStateWarning,
@@ -156,8 +241,8 @@ func TestCheckArgs(t *testing.T) {
 			name: "valid CA file",
 			config: Config{
 				Host:     "example.com",
-				Warning:  30,
-				Critical: 7,
+				Warning:  "30",
+				Critical: "7",
 			},
 			setupFunc: func() (string, func()) {
 				tmpfile, err := os.CreateTemp("", "ca-*.pem")
@@ -195,8 +280,8 @@ func TestCheckArgs(t *testing.T) {
 			name: "pem mode skips hostname requirement",
 			config: Config{
 				PemFile:  "/tmp/test.pem",
-				Warning:  30,
-				Critical: 7,
+				Warning:  "30",
+				Critical: "7",
 			},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
@@ -206,8 +291,8 @@ func TestCheckArgs(t *testing.T) {
 			name: "pkcs12 without pass is rejected",
 			config: Config{
 				PKCS12File: "/tmp/test.p12",
-				Warning:    30,
-				Critical:   7,
+				Warning:    "30",
+				Critical:   "7",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
@@ -218,24 +303,133 @@ func TestCheckArgs(t *testing.T) {
 			config: Config{
 				PKCS12File: "/tmp/test.p12",
 				PKCS12Pass: "password",
-				Warning:    30,
-				Critical:   7,
+				Warning:    "30",
+				Critical:   "7",
 			},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
 		},
+		{
+			name: "key without pem",
+			config: Config{
+				Host:     "example.com",
+				KeyFile:  "/tmp/test.key",
+				Warning:  "30",
+				Critical: "7",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--key requires --pem",
+		},
+		{
+			name: "path mode skips hostname requirement",
+			config: Config{
+				Paths:           []string{"/etc/pki"},
+				UnreadableState: "warning",
+				Warning:         "30",
+				Critical:        "7",
+			},
+			wantStatus: sensu.CheckStateOK,
+			wantErr:    false,
+		},
+		{
+			name: "invalid unreadable state",
+			config: Config{
+				Paths:           []string{"/etc/pki"},
+				UnreadableState: "broken",
+				Warning:         "30",
+				Critical:        "7",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--unreadable-state must be one of",
+		},
+		{
+			name: "invalid exclude pattern",
+			config: Config{
+				Paths:           []string{"/etc/pki"},
+				ScanExclude:     []string{"["},
+				UnreadableState: "warning",
+				Warning:         "30",
+				Critical:        "7",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "is not a valid --include/--exclude pattern",
+		},
 		{
 			name: "invalid ip override",
 			config: Config{
 				Host:     "example.com",
 				IP:       "not-an-ip",
-				Warning:  30,
-				Critical: 7,
+				Warning:  "30",
+				Critical: "7",
 			},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--ip is not a valid IP address",
 		},
+		{
+			name: "invalid not-before grace",
+			config: Config{
+				Host:           "example.com",
+				Warning:        "30",
+				Critical:       "7",
+				NotBeforeGrace: "5 minutes",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--not-before-grace must be a duration",
+		},
+		{
+			name: "invalid max validity",
+			config: Config{
+				PemFile:     "/tmp/cert.pem",
+				Warning:     "30",
+				Critical:    "7",
+				MaxValidity: "-1",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--max-validity must be days or a duration",
+		},
+		{
+			name: "unknown ecdsa curve",
+			config: Config{
+				PemFile:     "/tmp/cert.pem",
+				Warning:     "30",
+				Critical:    "7",
+				ECDSACurves: []string{"P-512"},
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--ecdsa-curves: unknown curve",
+		},
+		{
+			name: "pins with path",
+			config: Config{
+				Paths:    []string{"/etc/ssl/certs"},
+				Warning:  "30",
+				Critical: "7",
+				PinSPKI:  []string{"sha256/" + base64.StdEncoding.EncodeToString(make([]byte, 32))},
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "cannot be used with --path",
+		},
+		{
+			name: "invalid change state",
+			config: Config{
+				PemFile:     "/tmp/cert.pem",
+				Warning:     "30",
+				Critical:    "7",
+				StateFile:   "/tmp/state.json",
+				ChangeState: "unknown",
+			},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--change-state must be one of",
+		},
 	}
 
 	for _, tt := range tests {
@@ -283,70 +477,82 @@ func TestCheckArgs(t *testing.T) {
 	}
 }
 
-// TestParsePemCert tests PEM certificate parsing.
-func TestParsePemCert(t *testing.T) {
-	priv, certDER := generateTestCertDER(t, 30)
-	_ = priv
+// TestParseCertificates tests reading PEM bundles, DER and PKCS#7 certificate files.
+func TestParseCertificates(t *testing.T) {
+	_, leafDER := generateTestCertDER(t, 30)
+	_, caDER := generateTestCertDER(t, 365)
 
 	var buf strings.Builder
-	_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
 	pemData := []byte(buf.String())
+	_ = pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a real key")})
+	_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	bundle := []byte(buf.String())
 
-	t.Run("valid PEM", func(t *testing.T) {
-		cert, err := parsePemCert(pemData)
-		if err != nil {
-			t.Fatalf("parsePemCert() unexpected error: %v", err)
-		}
-		if cert == nil {
-			t.Fatal("parsePemCert() returned nil cert")
-		}
-	})
+	p7b := buildPKCS7(t, leafDER, caDER)
+	var p7bPEM strings.Builder
+	_ = pem.Encode(&p7bPEM, &pem.Block{Type: "PKCS7", Bytes: p7b})
 
-	t.Run("invalid PEM", func(t *testing.T) {
-		_, err := parsePemCert([]byte("not pem data"))
-		if err == nil {
-			t.Error("parsePemCert() expected error for invalid PEM, got nil")
-		}
-	})
+	tests := []struct {
+		name      string
+		data      []byte
+		wantCerts int
+		wantErr   bool
+	}{
+		{"valid PEM", pemData, 1, false},
+		{"PEM bundle skips private key", bundle, 2, false},
+		{"DER", leafDER, 1, false},
+		{"DER PKCS#7", p7b, 2, false},
+		{"PEM PKCS#7", []byte(p7bPEM.String()), 2, false},
+		{"PEM without certificates", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), 0, true},
+		{"invalid PEM", []byte("not pem data"), 0, true},
+		{"empty input", []byte{}, 0, true},
+	}
 
-	t.Run("empty input", func(t *testing.T) {
-		_, err := parsePemCert([]byte{})
-		if err == nil {
-			t.Error("parsePemCert() expected error for empty input, got nil")
-		}
-	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, err := parseCertificates(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCertificates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(certs) != tt.wantCerts {
+				t.Errorf("parseCertificates() returned %d certs, want %d", len(certs), tt.wantCerts)
+			}
+		})
+	}
 }
 
 // TestCheckExpiry tests the expiry checking logic with varying certificate lifetimes.
 func TestCheckExpiry(t *testing.T) {
+	const day = 24 * time.Hour
 	tests := []struct {
 		name       string
-		days       int
-		warning    int
-		critical   int
+		expiresIn  time.Duration
+		warning    string
+		critical   string
 		wantStatus int
 	}{
-		{"expired", -5, 30, 7, sensu.CheckStateCritical},
-		{"within critical", 3, 30, 7, sensu.CheckStateCritical},
-		{"within critical boundary", 6, 30, 7, sensu.CheckStateCritical},
-		{"within warning", 15, 30, 7, sensu.CheckStateWarning},
-		{"within warning boundary", 29, 30, 7, sensu.CheckStateWarning},
-		{"ok", 60, 30, 7, sensu.CheckStateOK},
+		{"expired", -5 * day, "30", "7", sensu.CheckStateCritical},
+		{"within critical", 3 * day, "30", "7", sensu.CheckStateCritical},
+		{"within critical boundary", 6 * day, "30", "7", sensu.CheckStateCritical},
+		{"within warning", 15 * day, "30", "7", sensu.CheckStateWarning},
+		{"within warning boundary", 29 * day, "30", "7", sensu.CheckStateWarning},
+		{"ok", 60 * day, "30", "7", sensu.CheckStateOK},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			plugin = Config{Warning: tt.warning, Critical: tt.critical}
-
-			cert := &x509.Certificate{
-				NotAfter: time.Now().Add(time.Duration(tt.days) * 24 * time.Hour),
-			}
-			status, err := checkExpiry(cert, "test")
+			expiryPolicy, err := expiry.NewPolicy(tt.warning, tt.critical, "")
 			if err != nil {
-				t.Fatalf("checkExpiry() unexpected error: %v", err)
+				t.Fatal(err)
+			}
+			cert := &x509.Certificate{NotAfter: time.Now().Add(tt.expiresIn)}
+			status, headline, _ := checkExpiry(expiryPolicy, cert, "test")
+			if !strings.Contains(headline, "test") {
+				t.Errorf("checkExpiry() headline = %q, want it to name the source", headline)
 			}
 			if status != tt.wantStatus {
-				t.Errorf("checkExpiry() status = %v, want %v (days=%d)", status, tt.wantStatus, tt.days)
+				t.Errorf("checkExpiry() status = %v, want %v", status, tt.wantStatus)
 			}
 		})
 	}
@@ -365,7 +571,7 @@ func TestExecuteCheck(t *testing.T) {
 	}{
 		{
 			name:   "critical expiry",
-			config: Config{Warning: 30, Critical: 7, InsecureSkipVerify: true},
+			config: Config{Warning: "30", Critical: "7", InsecureSkipVerify: true},
 			setupFunc: func() (string, int, func()) {
 				return startTestTLSServer(t, 3)
 			},
@@ -373,7 +579,7 @@ func TestExecuteCheck(t *testing.T) {
 		},
 		{
 			name:   "warning expiry",
-			config: Config{Warning: 30, Critical: 7, InsecureSkipVerify: true},
+			config: Config{Warning: "30", Critical: "7", InsecureSkipVerify: true},
 			setupFunc: func() (string, int, func()) {
 				return startTestTLSServer(t, 15)
 			},
@@ -381,7 +587,7 @@ func TestExecuteCheck(t *testing.T) {
 		},
 		{
 			name:   "ok",
-			config: Config{Warning: 30, Critical: 7, InsecureSkipVerify: true},
+			config: Config{Warning: "30", Critical: "7", InsecureSkipVerify: true},
 			setupFunc: func() (string, int, func()) {
 				return startTestTLSServer(t, 365)
 			},
@@ -389,13 +595,13 @@ func TestExecuteCheck(t *testing.T) {
 		},
 		{
 			name:       "connection failure",
-			config:     Config{Host: "invalid.example.test", Port: 443, Warning: 30, Critical: 7},
+			config:     Config{Host: "invalid.example.test", Port: 443, Warning: "30", Critical: "7"},
 			wantStatus: sensu.CheckStateCritical,
 			wantErr:    true,
 		},
 		{
 			name:   "custom CA",
-			config: Config{Warning: 30, Critical: 7},
+			config: Config{Warning: "30", Critical: "7"},
 			setupFunc: func() (string, int, func()) {
 				host, port, caFile, cleanup := startTestTLSServerWithCA(t, 365)
 				caCertPool, err := corev2.LoadCACerts(caFile)
@@ -407,6 +613,28 @@ func TestExecuteCheck(t *testing.T) {
 			},
 			wantStatus: sensu.CheckStateOK,
 		},
+		{
+			name:   "custom CA with verified path expiry",
+			config: Config{Warning: "30", Critical: "7", VerifiedPathExpiry: true},
+			setupFunc: func() (string, int, func()) {
+				host, port, caFile, cleanup := startTestTLSServerWithCA(t, 20)
+				caCertPool, err := corev2.LoadCACerts(caFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tlsConfig.RootCAs = caCertPool
+				return host, port, cleanup
+			},
+			wantStatus: sensu.CheckStateWarning,
+		},
+		{
+			name:   "chain expiry",
+			config: Config{Warning: "30", Critical: "7", InsecureSkipVerify: true, ChainExpiry: true},
+			setupFunc: func() (string, int, func()) {
+				return startTestTLSServer(t, 3)
+			},
+			wantStatus: sensu.CheckStateCritical,
+		},
 	}
 
 	for _, tt := range tests {
@@ -441,7 +669,7 @@ func TestExecuteCheck(t *testing.T) {
 // TestExecuteCheckWithPEM tests the PEM file code path.
 func TestExecuteCheckWithPEM(t *testing.T) {
 	t.Run("nonexistent file returns critical", func(t *testing.T) {
-		plugin = Config{PemFile: "/nonexistent/cert.pem", Warning: 30, Critical: 7}
+		plugin = Config{PemFile: "/nonexistent/cert.pem", Warning: "30", Critical: "7"}
 		status, err := executeCheck(nil)
 		if err == nil {
 			t.Error("expected error for nonexistent PEM file")
@@ -455,7 +683,7 @@ func TestExecuteCheckWithPEM(t *testing.T) {
 		path, cleanup := writeTempPEMCert(t, 365)
 		defer cleanup()
 
-		plugin = Config{PemFile: path, Warning: 30, Critical: 7}
+		plugin = Config{PemFile: path, Warning: "30", Critical: "7"}
 		status, err := executeCheck(nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
@@ -469,7 +697,7 @@ func TestExecuteCheckWithPEM(t *testing.T) {
 		path, cleanup := writeTempPEMCert(t, 3)
 		defer cleanup()
 
-		plugin = Config{PemFile: path, Warning: 30, Critical: 7}
+		plugin = Config{PemFile: path, Warning: "30", Critical: "7"}
 		status, err := executeCheck(nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
@@ -480,10 +708,391 @@ func TestExecuteCheckWithPEM(t *testing.T) {
 	})
 }
 
-// TestExecuteCheckWithPKCS12 tests the PKCS12 file code path error cases.
+// TestExecuteCheckWithBundle tests that thresholds apply to every certificate
+// in a bundle unless --leaf-only is set.
+func TestExecuteCheckWithBundle(t *testing.T) {
+	_, leafDER := generateTestCertDER(t, 365)
+	_, intermediateDER := generateTestCertDER(t, 3)
+	f, err := os.CreateTemp("", "fullchain-*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+	_ = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: intermediateDER})
+	_ = f.Close()
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	tests := []struct {
+		name       string
+		leafOnly   bool
+		wantStatus int
+	}{
+		{"every certificate", false, sensu.CheckStateCritical},
+		{"leaf only", true, sensu.CheckStateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{PemFile: f.Name(), LeafOnly: tt.leafOnly, Warning: "30", Critical: "7"}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckWithPolicy tests that weak keys are critical in file and
+// directory modes, and only for the leaf with --leaf-only.
+func TestExecuteCheckWithPolicy(t *testing.T) {
+	_, leafDER := generateTestCertDER(t, 365)
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Weak Intermediate"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+	}
+	weakDER, err := x509.CreateCertificate(rand.Reader, template, template, &weakKey.PublicKey, weakKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fullchain.pem")
+	if err := os.WriteFile(path, append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: weakDER})...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		config     Config
+		wantStatus int
+	}{
+		{"weak key in bundle", Config{PemFile: path, MinRSABits: 2048}, sensu.CheckStateCritical},
+		{"weak key not checked with leaf only", Config{PemFile: path, LeafOnly: true, MinRSABits: 2048}, sensu.CheckStateOK},
+		{"policy disabled", Config{PemFile: path}, sensu.CheckStateOK},
+		{"weak key in directory", Config{Paths: []string{dir}, MinRSABits: 2048, UnreadableState: "warning"}, sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = tt.config
+			plugin.Warning, plugin.Critical = "30", "7"
+			var status int
+			var err error
+			output := captureStdout(t, func() { status, err = executeCheck(nil) })
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %v, want %v", status, tt.wantStatus)
+			}
+			if want := expiry.StateLabel(tt.wantStatus) + ": "; !strings.HasPrefix(output, want) {
+				t.Errorf("output = %q, want the headline to start with %q", output, want)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckWithPins tests certificate and SPKI pins against every
+// certificate in a bundle.
+func TestExecuteCheckWithPins(t *testing.T) {
+	_, leafDER := generateTestCertDER(t, 365)
+	_, intermediateDER := generateTestCertDER(t, 365)
+	intermediate, err := x509.ParseCertificate(intermediateDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "fullchain.pem")
+	if err := os.WriteFile(path, append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediateDER})...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	leafSum := sha256.Sum256(leafDER)
+	wrongSPKI := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		name       string
+		certPins   []string
+		spkiPins   []string
+		wantStatus int
+	}{
+		{"leaf cert pin", []string{hex.EncodeToString(leafSum[:])}, nil, sensu.CheckStateOK},
+		{"intermediate spki pin", nil, []string{wrongSPKI, pins.SPKIPin(intermediate)}, sensu.CheckStateOK},
+		{"no pin matches", nil, []string{wrongSPKI}, sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{PemFile: path, Warning: "30", Critical: "7", PinCert: tt.certPins, PinSPKI: tt.spkiPins}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckCertChange tests that replacing a certificate file between
+// runs is reported once, for --pem and for files found by --path.
+func TestExecuteCheckCertChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	write := func(days int) {
+		_, der := generateTestCertDER(t, days)
+		if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, mode := range []struct {
+		name   string
+		config Config
+	}{
+		{"pem", Config{PemFile: certFile}},
+		{"path", Config{Paths: []string{dir}, UnreadableState: "warning"}},
+	} {
+		t.Run(mode.name, func(t *testing.T) {
+			_ = os.Remove(stateFile)
+			write(365)
+			for _, run := range []struct {
+				name       string
+				replace    bool
+				wantStatus int
+			}{
+				{"first run", false, sensu.CheckStateOK},
+				{"same certificate", false, sensu.CheckStateOK},
+				{"replaced certificate", true, sensu.CheckStateWarning},
+				{"change recorded", false, sensu.CheckStateOK},
+			} {
+				if run.replace {
+					write(200)
+				}
+				plugin = mode.config
+				plugin.Warning, plugin.Critical = "30", "7"
+				plugin.StateFile, plugin.ChangeState = stateFile, "warning"
+				status, err := executeCheck(nil)
+				if err != nil {
+					t.Fatalf("%v: unexpected error: %v", run.name, err)
+				}
+				if status != run.wantStatus {
+					t.Errorf("%v: status = %v, want %v", run.name, status, run.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+// TestParsePrivateKey tests decoding private keys in every supported encoding.
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, _ := generateTestCertDER(t, 30)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8 := func(key any) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//nolint:staticcheck // legacy encrypted PEM is what older tooling writes
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encryptPKCS8(t, pkcs8(edKey), "secret")})
+
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		wantKey  crypto.Signer
+		wantErr  string
+	}{
+		{"RSA PKCS#1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), "", rsaKey, ""},
+		{"RSA PKCS#8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(rsaKey)}), "", rsaKey, ""},
+		{"ECDSA SEC 1", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), "", ecKey, ""},
+		{"ECDSA PKCS#8 DER", pkcs8(ecKey), "", ecKey, ""},
+		{"Ed25519 PKCS#8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(edKey)}), "", edKey, ""},
+		{"encrypted PKCS#8", encrypted, "secret", edKey, ""},
+		{"encrypted PKCS#8 wrong password", encrypted, "wrong", nil, "wrong --key-pass"},
+		{"encrypted PKCS#8 without password", encrypted, "", nil, "--key-pass is required"},
+		{"legacy encrypted PEM", pem.EncodeToMemory(legacy), "secret", rsaKey, ""},
+		{"certificate only", []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), "", nil, "no private key PEM block"},
+		{"garbage", []byte("not a key"), "", nil, "not a PKCS#1, PKCS#8 or SEC 1 private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parsePrivateKey(tt.data, tt.password)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parsePrivateKey() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePrivateKey() unexpected error: %v", err)
+			}
+			if !tt.wantKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Errorf("parsePrivateKey() returned a different key")
+			}
+		})
+	}
+}
+
+// TestExecuteCheckWithKey tests that --key must match the --pem certificate.
+func TestExecuteCheckWithKey(t *testing.T) {
+	dir := t.TempDir()
+	priv, certDER := generateTestCertDER(t, 365)
+	otherKey, _ := generateTestCertDER(t, 365)
+	certPath := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeKey := func(name string, key *rsa.PrivateKey) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name       string
+		keyFile    string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"matching key", writeKey("match.key", priv), sensu.CheckStateOK, false},
+		{"mismatched key", writeKey("other.key", otherKey), sensu.CheckStateCritical, false},
+		{"missing key", filepath.Join(dir, "missing.key"), sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{PemFile: certPath, KeyFile: tt.keyFile, Warning: "30", Critical: "7"}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckWithPaths tests scanning directories and glob patterns.
+func TestExecuteCheckWithPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, data []byte) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certPEM := func(days int) []byte {
+		_, der := generateTestCertDER(t, days)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	priv, _ := generateTestCertDER(t, 365)
+	_, expiringDER := generateTestCertDER(t, 3)
+
+	writeFile("a.pem", certPEM(365))
+	writeFile("key.pem", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}))
+	writeFile("bad.pem", []byte("not a certificate"))
+	writeFile("c.der", expiringDER)
+	writeFile("notes.txt", []byte("not a certificate either"))
+	writeFile("sub/b.crt", certPEM(15))
+
+	tests := []struct {
+		name       string
+		paths      []string
+		recursive  bool
+		exclude    []string
+		unreadable string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"directory", []string{dir}, false, nil, "warning", sensu.CheckStateCritical, false},
+		{"private keys are skipped", []string{dir}, false, []string{"c.der", "bad.pem"}, "warning", sensu.CheckStateOK, false},
+		{"recursive", []string{dir}, true, []string{"c.der", "bad.pem"}, "warning", sensu.CheckStateWarning, false},
+		{"unreadable state", []string{filepath.Join(dir, "bad*")}, false, nil, "critical", sensu.CheckStateCritical, false},
+		{"unreadable state ok", []string{filepath.Join(dir, "bad*"), filepath.Join(dir, "a.pem")}, false, nil, "ok", sensu.CheckStateOK, false},
+		{"critical outranks unknown", []string{filepath.Join(dir, "bad*"), filepath.Join(dir, "c.der")}, false, nil, "unknown", sensu.CheckStateCritical, false},
+		{"unknown outranks warning", []string{filepath.Join(dir, "bad*"), filepath.Join(dir, "sub/b.crt")}, false, nil, "unknown", sensu.CheckStateUnknown, false},
+		{"no files", []string{filepath.Join(dir, "*.none")}, false, nil, "warning", sensu.CheckStateCritical, true},
+		{"missing directory", []string{filepath.Join(dir, "missing")}, false, nil, "warning", sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{Paths: tt.paths, Recursive: tt.recursive, ScanInclude: defaultScanInclude, ScanExclude: tt.exclude,
+				UnreadableState: tt.unreadable, Warning: "30", Critical: "7"}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestReadCertFile tests reading every certificate from OpenSSL-written
+// PKCS#12 files, both PBES2 and legacy.
+func TestReadCertFile(t *testing.T) {
+	for _, name := range []string{"pbes2.p12", "legacy.p12"} {
+		t.Run(name, func(t *testing.T) {
+			plugin = Config{PKCS12Pass: "changeit"}
+			certs, err := readCertFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatalf("readCertFile() unexpected error: %v", err)
+			}
+			if len(certs) != 2 || certs[0].Subject.CommonName != "server.example.com" || certs[1].Subject.CommonName != "Test Root CA" {
+				t.Errorf("readCertFile() returned %d certs, want server.example.com and Test Root CA", len(certs))
+			}
+
+			plugin.PKCS12Pass = "wrong"
+			if _, err := readCertFile(filepath.Join("testdata", name)); err == nil || !strings.Contains(err.Error(), "cannot parse PKCS#12") {
+				t.Errorf("readCertFile() wrong password error = %v, want PKCS#12 failure", err)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckWithPKCS12 tests the PKCS12 file code path, including
+// OpenSSL-written files that carry the CA chain next to the leaf.
 func TestExecuteCheckWithPKCS12(t *testing.T) {
 	t.Run("nonexistent file returns critical", func(t *testing.T) {
-		plugin = Config{PKCS12File: "/nonexistent/cert.p12", PKCS12Pass: "pass", Warning: 30, Critical: 7}
+		plugin = Config{PKCS12File: "/nonexistent/cert.p12", PKCS12Pass: "pass", Warning: "30", Critical: "7"}
 		status, err := executeCheck(nil)
 		if err == nil {
 			t.Error("expected error for nonexistent PKCS12 file")
@@ -502,7 +1111,7 @@ func TestExecuteCheckWithPKCS12(t *testing.T) {
 		_ = f.Close()
 		defer func() { _ = os.Remove(f.Name()) }()
 
-		plugin = Config{PKCS12File: f.Name(), PKCS12Pass: "pass", Warning: 30, Critical: 7}
+		plugin = Config{PKCS12File: f.Name(), PKCS12Pass: "pass", Warning: "30", Critical: "7"}
 		status, err := executeCheck(nil)
 		if err == nil {
 			t.Error("expected error for invalid PKCS12 data")
@@ -511,6 +1120,19 @@ func TestExecuteCheckWithPKCS12(t *testing.T) {
 			t.Errorf("status = %v, want Critical", status)
 		}
 	})
+
+	for _, name := range []string{"pbes2.p12", "legacy.p12"} {
+		t.Run(name+" with CA chain", func(t *testing.T) {
+			plugin = Config{PKCS12File: filepath.Join("testdata", name), PKCS12Pass: "changeit", Warning: "30", Critical: "7"}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("executeCheck() unexpected error: %v", err)
+			}
+			if status != sensu.CheckStateOK {
+				t.Errorf("status = %v, want OK", status)
+			}
+		})
+	}
 }
 
 // TestTLSConfigBug verifies that custom CA certificates are applied to connections.
@@ -523,8 +1145,8 @@ func TestTLSConfigBug(t *testing.T) {
 	plugin = Config{
 		Host:          host,
 		Port:          port,
-		Warning:       30,
-		Critical:      7,
+		Warning:       "30",
+		Critical:      "7",
 		TrustedCAFile: caFile,
 	}
 	plugin.PluginConfig = sensu.PluginConfig{Name: "check-tls-cert"}
@@ -571,6 +1193,85 @@ func generateTestCertDER(t *testing.T, days int) (*rsa.PrivateKey, []byte) {
 	return priv, certDER
 }
 
+// buildPKCS7 wraps DER certificates in a degenerate PKCS#7 signedData bundle,
+// the format of .p7b files.
+func buildPKCS7(t *testing.T, certs ...[]byte) []byte {
+	t.Helper()
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	dataContent, err := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      asn1.RawValue{FullBytes: dataContent},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certs, nil)},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// encryptPKCS8 encrypts a PKCS#8 key with PBES2 (PBKDF2-HMAC-SHA256,
+// AES-256-CBC), as openssl pkcs8 -topk8 does by default.
+func encryptPKCS8(t *testing.T, der []byte, password string) []byte {
+	t.Helper()
+	salt, iv := []byte("saltsalt"), []byte("0123456789abcdef")
+	key, err := pbkdf2.Key(sha256.New, password, salt, 2048, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	plaintext := append(append([]byte{}, der...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	marshal := func(v any) []byte {
+		out, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	type pbkdf2Params struct {
+		Salt           []byte
+		IterationCount int
+		PRF            pkix.AlgorithmIdentifier
+	}
+	type pbes2Params struct {
+		KeyDerivationFunc pkix.AlgorithmIdentifier
+		EncryptionScheme  pkix.AlgorithmIdentifier
+	}
+	return marshal(struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}, Parameters: asn1.RawValue{FullBytes: marshal(pbes2Params{
+			KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}, Parameters: asn1.RawValue{FullBytes: marshal(pbkdf2Params{
+				Salt:           salt,
+				IterationCount: 2048,
+				PRF:            pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}, Parameters: asn1.NullRawValue},
+			})}},
+			EncryptionScheme: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}, Parameters: asn1.RawValue{FullBytes: marshal(iv)}},
+		})}},
+		EncryptedData: ciphertext,
+	})
+}
+
 func writeTempPEMCert(t *testing.T, days int) (path string, cleanup func()) {
 	t.Helper()
 	_, certDER := generateTestCertDER(t, days)
@@ -672,3 +1373,23 @@ func serveConnections(l net.Listener) {
 		}(conn)
 	}
 }
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	_ = w.Close()
+	return string(<-done)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/certpolicy"
+	"github.com/nmollerup/sensu-check-tls/internal/certstate"
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+	"github.com/nmollerup/sensu-check-tls/internal/pkcs12"
+	"github.com/nmollerup/sensu-check-tls/internal/statefile"
+)
+
+// scanStates maps the --unreadable-state names to check states.
+var scanStates = map[string]int{
+	"ok":       sensu.CheckStateOK,
+	"warning":  sensu.CheckStateWarning,
+	"critical": sensu.CheckStateCritical,
+	"unknown":  sensu.CheckStateUnknown,
+}
+
+// defaultScanInclude is the --include default: the usual certificate file extensions.
+var defaultScanInclude = []string{"*.pem", "*.crt", "*.cer", "*.der", "*.p7b", "*.p7c", "*.p12", "*.pfx"}
+
+// fileResult is the outcome of checking one file found by --path.
+type fileResult struct {
+	path    string
+	state   int
+	skipped bool
+	failed  bool
+	detail  string
+}
+
+// errPrivateKeyFile marks a file that holds private keys and no certificates.
+var errPrivateKeyFile = errors.New("private key")
+
+// scanFiles expands --path into the files to check. Directories are listed,
+// or walked with --recursive, and only their files matching --include are
+// kept; glob patterns and plain files are taken as given. Files matching
+// --exclude are always dropped.
+func scanFiles() ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	add := func(path string, filtered bool) {
+		name := filepath.Base(path)
+		if seen[path] || matchesAny(plugin.ScanExclude, name) || (filtered && len(plugin.ScanInclude) > 0 && !matchesAny(plugin.ScanInclude, name)) {
+			return
+		}
+		seen[path] = true
+		files = append(files, path)
+	}
+
+	for _, pattern := range plugin.Paths {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid --path pattern %q: %v", pattern, err)
+			}
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read --path %v: %v", match, err)
+			}
+			if !info.IsDir() {
+				add(match, false)
+				continue
+			}
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					// Unreadable subdirectories are reported like unreadable files.
+					if path != match {
+						add(path, false)
+						return fs.SkipDir
+					}
+					return err
+				}
+				if d.IsDir() {
+					if path != match && !plugin.Recursive {
+						return fs.SkipDir
+					}
+					return nil
+				}
+				add(path, true)
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot read directory %v: %v", match, err)
+			}
+		}
+	}
+	return files, nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readCertFile returns the certificates in a PEM, DER, PKCS#7 or PKCS#12
+// file. Files holding only private keys return errPrivateKeyFile.
+func readCertFile(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificates(data)
+	if err == nil {
+		return certs, nil
+	}
+	if isPrivateKey(data) {
+		return nil, errPrivateKeyFile
+	}
+	if bags, _, p12Err := pkcs12.Decode(data, plugin.PKCS12Pass); p12Err == nil {
+		p12Certs := make([]*x509.Certificate, 0, len(bags))
+		for _, bag := range bags {
+			p12Certs = append(p12Certs, bag.Cert)
+		}
+		if len(p12Certs) > 0 {
+			return p12Certs, nil
+		}
+	} else if ext := strings.ToLower(filepath.Ext(path)); ext == ".p12" || ext == ".pfx" {
+		return nil, fmt.Errorf("cannot parse PKCS#12: %v", p12Err)
+	}
+	return nil, err
+}
+
+// isPrivateKey reports whether data is a PEM file with a private key block, or
+// a DER PKCS#8, PKCS#1 or SEC 1 private key.
+func isPrivateKey(data []byte) bool {
+	if block, _ := pem.Decode(data); block != nil {
+		for ; block != nil; block, data = pem.Decode(data) {
+			if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+				return true
+			}
+		}
+		return false
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+		return true
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return true
+	}
+	_, err := x509.ParseECPrivateKey(data)
+	return err == nil
+}
+
+// checkFile applies the expiry thresholds and the crypto policy to the
+// certificates in path, or only to the first one with --leaf-only. With a non-nil seen, a change of the
+// first certificate since the last run is reported too.
+func checkFile(expiryPolicy expiry.Policy, cryptoPolicy certpolicy.Policy, path string, timeNow time.Time, seen certstate.SeenCerts) fileResult {
+	certs, err := readCertFile(path)
+	switch {
+	case errors.Is(err, errPrivateKeyFile):
+		return fileResult{path: path, skipped: true, detail: "private key"}
+	case err != nil:
+		return fileResult{path: path, state: scanStates[plugin.UnreadableState], failed: true, detail: err.Error()}
+	}
+	if plugin.LeafOnly {
+		certs = certs[:1]
+	}
+	state, closest := sensu.CheckStateOK, 0
+	for i, cert := range certs {
+		if certState, _ := expiryPolicy.State(cert, timeNow); certState > state {
+			state = certState
+		}
+		if cert.NotAfter.Before(certs[closest].NotAfter) {
+			closest = i
+		}
+	}
+	detail := fmt.Sprintf("%d certs, closest to expiry is %q, %v", len(certs), certs[closest].Subject.String(), expiry.DescribeValidity(certs[closest], timeNow))
+	for i, cert := range certs {
+		if i != closest && expiryPolicy.NotYetValid(cert, timeNow) {
+			detail += fmt.Sprintf("; %q %v", cert.Subject.String(), expiry.DescribeValidity(cert, timeNow))
+		}
+	}
+	for _, cert := range certs {
+		for _, violation := range cryptoPolicy.Violations(cert) {
+			state = sensu.CheckStateCritical
+			detail += fmt.Sprintf("; %q %v", cert.Subject.String(), violation)
+		}
+	}
+	if seen != nil {
+		if changes := seen.Changes(path, certs[0]); len(changes) > 0 {
+			if changeState := certstate.ChangeStates[plugin.ChangeState]; changeState > state {
+				state = changeState
+			}
+			detail += fmt.Sprintf("; leaf changed since the last run (%v)", strings.Join(changes, ", "))
+		}
+	}
+	return fileResult{path: path, state: state, detail: detail}
+}
+
+// severity ranks check states from ok to critical. Unknown, which
+// --unreadable-state can give unreadable files, ranks below critical so that
+// an unreadable file never hides an expired certificate.
+func severity(state int) int {
+	switch state {
+	case sensu.CheckStateCritical:
+		return 3
+	case sensu.CheckStateUnknown:
+		return 2
+	}
+	return state
+}
+
+// checkPaths checks every certificate file found by --path and reports each
+// file. The state is the worst across all files, critical ranking above
+// unknown.
+func checkPaths(expiryPolicy expiry.Policy, cryptoPolicy certpolicy.Policy) (int, error) {
+	files, err := scanFiles()
+	if err != nil {
+		return sensu.CheckStateCritical, err
+	}
+	if len(files) == 0 {
+		return sensu.CheckStateCritical, fmt.Errorf("no certificate files found in %v", strings.Join(plugin.Paths, ", "))
+	}
+
+	var seen certstate.SeenCerts
+	if len(plugin.StateFile) > 0 {
+		unlock, err := statefile.Lock(plugin.StateFile)
+		if err != nil {
+			return sensu.CheckStateWarning, err
+		}
+		defer unlock()
+		if seen, err = certstate.Load(plugin.StateFile); err != nil {
+			return sensu.CheckStateWarning, err
+		}
+	}
+
+	timeNow := time.Now()
+	worst, worstFile, failed, skipped := sensu.CheckStateOK, -1, 0, 0
+	results := make([]fileResult, len(files))
+	for i, path := range files {
+		results[i] = checkFile(expiryPolicy, cryptoPolicy, path, timeNow, seen)
+		switch {
+		case results[i].skipped:
+			skipped++
+			continue
+		case results[i].failed:
+			failed++
+		}
+		if worstFile < 0 || severity(results[i].state) > severity(worst) {
+			worst, worstFile = results[i].state, i
+		}
+	}
+
+	var saveErr error
+	if seen != nil {
+		if saveErr = seen.Save(plugin.StateFile); saveErr != nil && worst < sensu.CheckStateWarning {
+			worst = sensu.CheckStateWarning
+		}
+	}
+
+	summary := fmt.Sprintf("%d files checked, %d unreadable, %d skipped", len(files)-skipped, failed, skipped)
+	if worstFile >= 0 && worst != sensu.CheckStateOK {
+		summary += fmt.Sprintf(", worst is %v", results[worstFile].path)
+	}
+	fmt.Printf("%v: %v\n", expiry.StateLabel(worst), summary)
+	if saveErr != nil {
+		fmt.Printf("warning: %v\n", saveErr)
+	}
+	for _, result := range results {
+		switch {
+		case result.skipped:
+			fmt.Printf("skipped: %v (%v)\n", result.path, result.detail)
+		case result.failed:
+			fmt.Printf("%v: %v unreadable: %v\n", expiry.StateLabel(result.state), result.path, result.detail)
+		default:
+			fmt.Printf("%v: %v %v\n", expiry.StateLabel(result.state), result.path, result.detail)
+		}
+	}
+	return worst, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nmollerup/sensu-check-tls/internal/pkcs12"
+)
+
+// The CRLReason codes that do not mean the certificate is revoked for good.
+const (
+	reasonCertificateHold = 6
+	// reasonRemoveFromCRL only appears in delta CRLs, taking a certificate
+	// listed on hold in the base CRL off the list again.
+	reasonRemoveFromCRL = 8
+)
+
+// crlReasons names the RFC 5280 CRLReason codes.
+var crlReasons = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+// certSources returns how many of --cert, --pkcs12 and --host are set.
+func certSources() int {
+	n := 0
+	for _, source := range []string{plugin.Cert, plugin.PKCS12, plugin.Host} {
+		if len(source) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// loadCertificate reads the certificate named by --cert, --pkcs12 or --host.
+// From a PKCS#12 file it takes the certificate for the private key, as the
+// file may also carry the CA chain.
+func loadCertificate() (*x509.Certificate, error) {
+	switch {
+	case len(plugin.Cert) > 0:
+		data, err := os.ReadFile(plugin.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("reading certificate: %v", err)
+		}
+		if block, _ := pem.Decode(data); block != nil {
+			data = block.Bytes
+		}
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse certificate from %v: %v", plugin.Cert, err)
+		}
+		return cert, nil
+	case len(plugin.PKCS12) > 0:
+		data, err := os.ReadFile(plugin.PKCS12)
+		if err != nil {
+			return nil, fmt.Errorf("reading PKCS#12 file: %v", err)
+		}
+		_, cert, err := pkcs12.DecodeLeaf(data, plugin.PKCS12Pass)
+		if err != nil {
+			return nil, fmt.Errorf("decoding PKCS#12 file %v: %v", plugin.PKCS12, err)
+		}
+		return cert, nil
+	default:
+		return fetchPeerCertificate(plugin.Host)
+	}
+}
+
+// fetchPeerCertificate returns the leaf certificate served at hostport. The
+// chain is not verified: the CRL is the authority being asked here.
+func fetchPeerCertificate(hostport string) (*x509.Certificate, error) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, fmt.Errorf("--host must be host:port: %v", err)
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(plugin.Timeout) * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", hostport, &tls.Config{ServerName: host, InsecureSkipVerify: true}) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %v: %v", hostport, err)
+	}
+	defer func() { _ = conn.Close() }()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates returned by %v", hostport)
+	}
+	return certs[0], nil
+}
+
+// parseSerial parses a hexadecimal serial number as printed by openssl,
+// optionally prefixed with 0x and separated by colons.
+func parseSerial(s string) (*big.Int, error) {
+	hex := strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(s), "0x"), ":", "")
+	serial, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		return nil, fmt.Errorf("--serial %q is not a hexadecimal serial number", s)
+	}
+	return serial, nil
+}
+
+// findRevoked returns the CRL entry for serial, or nil if it is not listed.
+func findRevoked(crl *x509.RevocationList, serial *big.Int) *x509.RevocationListEntry {
+	for i := range crl.RevokedCertificateEntries {
+		if crl.RevokedCertificateEntries[i].SerialNumber.Cmp(serial) == 0 {
+			return &crl.RevokedCertificateEntries[i]
+		}
+	}
+	return nil
+}
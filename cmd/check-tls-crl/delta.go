@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"net/url"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+var (
+	// oidDeltaCRLIndicator marks a delta CRL and carries its base CRL number (RFC 5280, 5.2.4).
+	oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	// oidFreshestCRL lists where the delta CRLs for a complete CRL are published (RFC 5280, 5.2.6).
+	oidFreshestCRL = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+// distributionPoint and distributionPointName mirror the ASN.1 DistributionPoint
+// structure shared by the CRL Distribution Points and Freshest CRL extensions.
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+type distributionPointName struct {
+	FullName     []asn1.RawValue  `asn1:"optional,tag:0"`
+	RelativeName pkix.RDNSequence `asn1:"optional,tag:1"`
+}
+
+// generalNameURI is the context-specific tag of a uniformResourceIdentifier GeneralName.
+const generalNameURI = 6
+
+// findExtension returns the value of the CRL extension with the given id.
+func findExtension(crl *x509.RevocationList, id asn1.ObjectIdentifier) ([]byte, bool) {
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(id) {
+			return ext.Value, true
+		}
+	}
+	return nil, false
+}
+
+// deltaIndicator returns the base CRL number of a delta CRL, or nil if crl is
+// a complete CRL.
+func deltaIndicator(crl *x509.RevocationList) (*big.Int, error) {
+	value, ok := findExtension(crl, oidDeltaCRLIndicator)
+	if !ok {
+		return nil, nil
+	}
+	baseNumber := new(big.Int)
+	if _, err := asn1.Unmarshal(value, &baseNumber); err != nil {
+		return nil, fmt.Errorf("malformed Delta CRL Indicator: %v", err)
+	}
+	return baseNumber, nil
+}
+
+// freshestCRLPoints returns the HTTP(S) URLs in crl's Freshest CRL extension.
+func freshestCRLPoints(crl *x509.RevocationList) ([]string, error) {
+	value, ok := findExtension(crl, oidFreshestCRL)
+	if !ok {
+		return nil, nil
+	}
+	var points []distributionPoint
+	if _, err := asn1.Unmarshal(value, &points); err != nil {
+		return nil, fmt.Errorf("malformed Freshest CRL extension: %v", err)
+	}
+	var locations []string
+	for _, point := range points {
+		for _, name := range point.DistributionPoint.FullName {
+			if name.Class != asn1.ClassContextSpecific || name.Tag != generalNameURI {
+				continue
+			}
+			if u, err := url.Parse(string(name.Bytes)); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				locations = append(locations, string(name.Bytes))
+			}
+		}
+	}
+	return locations, nil
+}
+
+// deltaCRL is a delta CRL that applies to the complete CRL being checked.
+type deltaCRL struct {
+	crl      *x509.RevocationList
+	location string
+}
+
+// fetchDeltas fetches the delta CRLs named by base's Freshest CRL extension
+// and checks that they apply to base. Each failing delta is reported on its
+// own line and makes the returned state critical.
+func (c *crlCheck) fetchDeltas(base *x509.RevocationList, source string) ([]deltaCRL, int) {
+	if baseNumber, _ := deltaIndicator(base); baseNumber != nil {
+		return nil, sensu.CheckStateOK
+	}
+	locations, err := freshestCRLPoints(base)
+	if err != nil {
+		fmt.Printf("critical: %v - %v\n", source, err)
+		return nil, sensu.CheckStateCritical
+	}
+
+	var deltas []deltaCRL
+	status := sensu.CheckStateOK
+	for _, location := range locations {
+		crls, err := c.fetchDelta(base, location)
+		if err != nil {
+			fmt.Printf("critical: delta CRL %v - %v\n", location, err)
+			status = sensu.CheckStateCritical
+			continue
+		}
+		for _, crl := range crls {
+			deltas = append(deltas, deltaCRL{crl: crl, location: location})
+		}
+	}
+	return deltas, status
+}
+
+// fetchDelta returns the CRLs at location after checking that they are delta
+// CRLs from base's issuer that apply to base.
+func (c *crlCheck) fetchDelta(base *x509.RevocationList, location string) ([]*x509.RevocationList, error) {
+	data, err := c.fetchTimed(location, "delta CRL "+location)
+	if err != nil {
+		return nil, err
+	}
+	deltas, err := parseCRLs(data, location)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, delta := range deltas {
+		baseNumber, err := deltaIndicator(delta)
+		if err != nil {
+			return nil, err
+		}
+		if baseNumber == nil {
+			return nil, fmt.Errorf("not a delta CRL (no Delta CRL Indicator)")
+		}
+		if !bytes.Equal(delta.RawIssuer, base.RawIssuer) {
+			return nil, fmt.Errorf("delta CRL issuer %q does not match base CRL issuer %q", delta.Issuer, base.Issuer)
+		}
+		if base.Number != nil && baseNumber.Cmp(base.Number) > 0 {
+			return nil, fmt.Errorf("delta CRL requires base CRL number %v or later, base CRL is number %v", baseNumber, base.Number)
+		}
+	}
+	return deltas, nil
+}
+
+// releasedBy returns the delta CRL that removes serial from its base with the
+// removeFromCRL reason, which releases a certificate on hold, or nil.
+func releasedBy(deltas []deltaCRL, serial *big.Int) *deltaCRL {
+	for i := range deltas {
+		if entry := findRevoked(deltas[i].crl, serial); entry != nil && entry.ReasonCode == reasonRemoveFromCRL {
+			return &deltas[i]
+		}
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,11 +19,24 @@ import (
 
 type Config struct {
 	sensu.PluginConfig
-	URL      string
-	Critical int
-	Warning  int
+	URL                string
+	IssuerCert         string
+	Cert               string
+	PKCS12             string
+	PKCS12Pass         string
+	Host               string
+	Serial             string
+	Timeout            int
+	StateFile          string
+	Critical           int
+	Warning            int
+	ThisUpdateCritical int
+	ThisUpdateWarning  int
 }
 
+// gzipMagic starts every gzip stream (RFC 1952).
+var gzipMagic = []byte{0x1f, 0x8b}
+
 var (
 	plugin = Config{
 		PluginConfig: sensu.PluginConfig{
@@ -48,6 +65,57 @@ var (
 			Usage:     "Minutes before CRL expiry to warn",
 			Value:     &plugin.Warning,
 		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "issuer-cert",
+			Usage:    "URL or file path to the issuing CA certificate (PEM or DER) to verify the CRL signature against",
+			Value:    &plugin.IssuerCert,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "cert",
+			Usage:    "Path to a certificate (PEM or DER) to look up in the CRL",
+			Value:    &plugin.Cert,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "pkcs12",
+			Usage:    "Path to a PKCS#12 file whose certificate is looked up in the CRL",
+			Value:    &plugin.PKCS12,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "pass",
+			Usage:    "Password for the PKCS#12 file",
+			Value:    &plugin.PKCS12Pass,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "host",
+			Usage:    "host:port of a TLS server whose certificate is looked up in the CRL",
+			Value:    &plugin.Host,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "serial",
+			Usage:    "Hexadecimal serial number to look up in the CRL",
+			Value:    &plugin.Serial,
+		},
+		&sensu.PluginConfigOption[int]{
+			Argument: "timeout",
+			Default:  30,
+			Usage:    "Timeout in seconds for --host connections and each HTTP(S) fetch",
+			Value:    &plugin.Timeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "state-file",
+			Usage:    "Path to a file recording the last CRL number seen per URL; a CRL number going backwards is critical",
+			Value:    &plugin.StateFile,
+		},
+		&sensu.PluginConfigOption[int]{
+			Argument: "this-update-critical",
+			Usage:    "Minutes since the CRL's ThisUpdate to go critical (0 disables)",
+			Value:    &plugin.ThisUpdateCritical,
+		},
+		&sensu.PluginConfigOption[int]{
+			Argument: "this-update-warning",
+			Usage:    "Minutes since the CRL's ThisUpdate to warn (0 disables)",
+			Value:    &plugin.ThisUpdateWarning,
+		},
 	}
 )
 
@@ -57,8 +125,8 @@ func main() {
 }
 
 func checkArgs(event *corev2.Event) (int, error) {
-	if len(plugin.URL) == 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--url is required")
+	if len(plugin.URL) == 0 && certSources() == 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--url is required unless --cert, --pkcs12 or --host is given")
 	}
 	if plugin.Critical <= 0 {
 		return sensu.CheckStateWarning, fmt.Errorf("--critical is required")
@@ -69,50 +137,350 @@ func checkArgs(event *corev2.Event) (int, error) {
 	if plugin.Warning < plugin.Critical {
 		return sensu.CheckStateWarning, fmt.Errorf("--warning cannot be less than --critical")
 	}
+	if plugin.ThisUpdateCritical > 0 && plugin.ThisUpdateWarning > plugin.ThisUpdateCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--this-update-warning cannot be greater than --this-update-critical")
+	}
+	if certSources() > 1 || (certSources() == 1 && len(plugin.Serial) > 0) {
+		return sensu.CheckStateWarning, fmt.Errorf("only one of --cert, --pkcs12, --host and --serial can be used")
+	}
 	return sensu.CheckStateOK, nil
 }
 
-func fetchCRL() ([]byte, error) {
-	u, err := url.Parse(plugin.URL)
+// fetch reads location over HTTP(S), within --timeout, or, for anything else,
+// from the local filesystem.
+func fetch(location string) ([]byte, error) {
+	u, err := url.Parse(location)
 	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
-		return os.ReadFile(plugin.URL)
+		return os.ReadFile(location)
 	}
-	resp, err := http.Get(plugin.URL) //nolint:gosec
+	client := &http.Client{Timeout: time.Duration(plugin.Timeout) * time.Second}
+	resp, err := client.Get(location) //nolint:gosec
 	if err != nil {
-		return nil, fmt.Errorf("fetching CRL from %v: %v", plugin.URL, err)
+		return nil, fmt.Errorf("fetching %v: %v", location, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected HTTP status %v fetching %v", resp.Status, plugin.URL)
+		return nil, fmt.Errorf("unexpected HTTP status %v fetching %v", resp.Status, location)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v: %v", location, err)
+	}
+	return data, nil
+}
+
+// parseCRLs decodes the CRLs in data. Gzip-compressed payloads are detected by
+// their magic bytes; PEM input may hold several X509 CRL blocks, anything else
+// is parsed as a single DER CRL.
+func parseCRLs(data []byte, source string) ([]*x509.RevocationList, error) {
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress gzip CRL from %v: %v", source, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("cannot decompress gzip CRL from %v: %v", source, err)
+		}
+	}
+
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CRL from %v as DER: %v", source, err)
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+
+	var crls []*x509.RevocationList
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse PEM CRL block %d from %v: %v", len(crls)+1, source, err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, fmt.Errorf("cannot parse CRL from %v as PEM: no X509 CRL blocks found", source)
+	}
+	return crls, nil
+}
+
+// loadIssuerCert fetches and parses the --issuer-cert certificate, PEM or DER.
+func loadIssuerCert() (*x509.Certificate, error) {
+	data, err := fetch(plugin.IssuerCert)
+	if err != nil {
+		return nil, fmt.Errorf("reading issuer certificate: %v", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse issuer certificate from %v: %v", plugin.IssuerCert, err)
+	}
+	return cert, nil
+}
+
+// verifyIssuer checks that crl names issuer as its issuer, by DN and Authority
+// Key Identifier, and that issuer's key produced the CRL signature.
+func verifyIssuer(crl *x509.RevocationList, issuer *x509.Certificate) error {
+	if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) {
+		return fmt.Errorf("CRL issuer %q does not match issuer certificate subject %q", crl.Issuer, issuer.Subject)
+	}
+	if len(crl.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 && !bytes.Equal(crl.AuthorityKeyId, issuer.SubjectKeyId) {
+		return fmt.Errorf("CRL authority key identifier %x does not match issuer certificate subject key identifier %x", crl.AuthorityKeyId, issuer.SubjectKeyId)
 	}
-	return io.ReadAll(resp.Body)
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("CRL signature does not verify against %q: %v", issuer.Subject, err)
+	}
+	return nil
 }
 
 func executeCheck(event *corev2.Event) (int, error) {
-	data, err := fetchCRL()
+	var cert *x509.Certificate
+	if certSources() > 0 {
+		var err error
+		if cert, err = loadCertificate(); err != nil {
+			return sensu.CheckStateCritical, err
+		}
+	}
+
+	locations := []string{plugin.URL}
+	if len(plugin.URL) == 0 {
+		locations = distributionPoints(cert)
+		if len(locations) == 0 {
+			return sensu.CheckStateCritical, fmt.Errorf("certificate %q has no HTTP(S) CRL distribution points", cert.Subject)
+		}
+	}
+
+	var issuer *x509.Certificate
+	if len(plugin.IssuerCert) > 0 {
+		var err error
+		if issuer, err = loadIssuerCert(); err != nil {
+			return sensu.CheckStateCritical, err
+		}
+	}
+
+	var target revocationTarget
+	switch {
+	case cert != nil:
+		target = revocationTarget{serial: cert.SerialNumber, description: fmt.Sprintf("certificate %q (serial %X)", cert.Subject, cert.SerialNumber)}
+	case len(plugin.Serial) > 0:
+		serial, err := parseSerial(plugin.Serial)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		target = revocationTarget{serial: serial, description: fmt.Sprintf("serial %X", serial)}
+	}
+
+	check := &crlCheck{issuer: issuer, target: target}
+	if len(plugin.StateFile) > 0 {
+		numbers, err := loadCRLNumbers(plugin.StateFile)
+		if err != nil {
+			return sensu.CheckStateWarning, err
+		}
+		check.numbers = numbers
+	}
+
+	var status int
+	var err error
+	if len(locations) == 1 {
+		status, err = check.checkLocation(locations[0])
+	} else {
+		for _, location := range locations {
+			pointStatus, pointErr := check.checkLocation(location)
+			if pointErr != nil {
+				fmt.Printf("critical: %v - %v\n", location, pointErr)
+			}
+			if pointStatus > status {
+				status = pointStatus
+			}
+		}
+	}
+
+	if check.numbers != nil {
+		if saveErr := check.numbers.save(plugin.StateFile); saveErr != nil {
+			fmt.Printf("warning: %v\n", saveErr)
+			if status < sensu.CheckStateWarning {
+				status = sensu.CheckStateWarning
+			}
+		}
+	}
+	// The metrics gathered so far are reported even when the check fails.
+	if len(check.metrics) > 0 {
+		fmt.Printf("| %v\n", check.perfdata())
+	}
+	return status, err
+}
+
+// crlCheck holds what every fetched CRL is checked against.
+type crlCheck struct {
+	issuer  *x509.Certificate
+	target  revocationTarget
+	numbers crlNumbers
+	metrics []metric
+}
+
+// revocationTarget is the serial number looked up in each CRL, if any.
+type revocationTarget struct {
+	serial      *big.Int
+	description string
+}
+
+// distributionPoints returns the HTTP(S) CRL distribution points of cert.
+// LDAP and other schemes are skipped.
+func distributionPoints(cert *x509.Certificate) []string {
+	var points []string
+	for _, point := range cert.CRLDistributionPoints {
+		if u, err := url.Parse(point); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// checkLocation fetches and parses the CRLs at location and checks each of
+// them, following any Freshest CRL pointer of a complete CRL to its delta.
+func (c *crlCheck) checkLocation(location string) (int, error) {
+	data, err := c.fetchTimed(location, location)
 	if err != nil {
 		return sensu.CheckStateCritical, err
 	}
 
-	crl, err := x509.ParseRevocationList(data)
+	crls, err := parseCRLs(data, location)
 	if err != nil {
-		return sensu.CheckStateCritical, fmt.Errorf("cannot parse CRL from %v: %v", plugin.URL, err)
+		return sensu.CheckStateCritical, err
+	}
+
+	status := sensu.CheckStateOK
+	seen := make(map[string]int)
+	for i, crl := range crls {
+		source, key := location, location
+		if len(crls) > 1 {
+			source = fmt.Sprintf("%v (CRL %d of %d, %q)", location, i+1, len(crls), crl.Issuer)
+			key = fmt.Sprintf("%v#%v", location, crl.Issuer)
+			// A bundle can hold several CRLs from one issuer, e.g. one per
+			// partition; number the repeats so each keeps its own CRL number.
+			seen[key]++
+			if seen[key] > 1 {
+				key = fmt.Sprintf("%v#%d", key, seen[key])
+			}
+		}
+		deltas, deltaStatus := c.fetchDeltas(crl, source)
+		if deltaStatus > status {
+			status = deltaStatus
+		}
+		crlStatus, err := c.checkRevocationList(crl, source, key, deltas)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		if crlStatus > status {
+			status = crlStatus
+		}
+		for _, delta := range deltas {
+			deltaStatus, err := c.checkRevocationList(delta.crl, "delta CRL "+delta.location, delta.location, nil)
+			if err != nil {
+				fmt.Printf("critical: delta CRL %v - %v\n", delta.location, err)
+				deltaStatus = sensu.CheckStateCritical
+			}
+			if deltaStatus > status {
+				status = deltaStatus
+			}
+		}
+	}
+	return status, nil
+}
+
+// checkRevocationList verifies crl against the issuer when given, looks up the
+// target serial, applies the NextUpdate thresholds and checks that the CRL
+// number recorded under key has not gone backwards. A target on hold in crl
+// counts as released when one of deltas removes it with removeFromCRL.
+func (c *crlCheck) checkRevocationList(crl *x509.RevocationList, source, key string, deltas []deltaCRL) (int, error) {
+	if c.issuer != nil {
+		if err := verifyIssuer(crl, c.issuer); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("%v: %v", source, err)
+		}
+	}
+
+	status := sensu.CheckStateOK
+	var notRevoked string
+	if c.target.serial != nil {
+		notRevoked = fmt.Sprintf("%v not revoked per %v", c.target.description, source)
+		if revoked := findRevoked(crl, c.target.serial); revoked != nil {
+			release := releasedBy(deltas, c.target.serial)
+			switch {
+			case revoked.ReasonCode == reasonRemoveFromCRL:
+				notRevoked = fmt.Sprintf("%v removed from the CRL (removeFromCRL) per %v", c.target.description, source)
+			case revoked.ReasonCode == reasonCertificateHold && release != nil:
+				notRevoked = fmt.Sprintf("%v on hold per %v, released per delta CRL %v", c.target.description, source, release.location)
+			default:
+				fmt.Printf("critical: %v revoked at %v (reason: %v) per %v\n", c.target.description, revoked.RevocationTime, crlReasons[revoked.ReasonCode], source)
+				status = sensu.CheckStateCritical
+				notRevoked = ""
+			}
+		}
+	}
+	if nextUpdateStatus := checkNextUpdate(crl, source); nextUpdateStatus > status {
+		status = nextUpdateStatus
 	}
+	if thisUpdateStatus := checkThisUpdate(crl, source); thisUpdateStatus > status {
+		status = thisUpdateStatus
+	}
+	c.addMetric(source, "crl_revoked_entries", len(crl.RevokedCertificateEntries), "")
+	c.addMetric(source, "crl_next_update_minutes", int(time.Until(crl.NextUpdate).Minutes()), "")
+	if c.numbers != nil {
+		if numberStatus := c.numbers.check(key, crl, source); numberStatus > status {
+			status = numberStatus
+		}
+	}
+	if notRevoked != "" {
+		fmt.Println(notRevoked)
+	}
+	if c.issuer != nil {
+		fmt.Printf("signature verified against %q\n", c.issuer.Subject)
+	}
+	return status, nil
+}
+
+// checkThisUpdate applies the ThisUpdate age thresholds, if set, to spot a CA
+// that has stopped re-issuing its CRL.
+func checkThisUpdate(crl *x509.RevocationList, source string) int {
+	minutesAgo := int(time.Since(crl.ThisUpdate).Minutes())
 
+	if plugin.ThisUpdateCritical > 0 && minutesAgo > plugin.ThisUpdateCritical {
+		fmt.Printf("critical: %v - issued %v minutes ago at %v\n", source, minutesAgo, crl.ThisUpdate)
+		return sensu.CheckStateCritical
+	}
+	if plugin.ThisUpdateWarning > 0 && minutesAgo > plugin.ThisUpdateWarning {
+		fmt.Printf("warning: %v - issued %v minutes ago at %v\n", source, minutesAgo, crl.ThisUpdate)
+		return sensu.CheckStateWarning
+	}
+	return sensu.CheckStateOK
+}
+
+// checkNextUpdate applies the warning and critical thresholds to the CRL's NextUpdate.
+func checkNextUpdate(crl *x509.RevocationList, source string) int {
 	minutesUntil := int(time.Until(crl.NextUpdate).Minutes())
 
 	if minutesUntil < 0 {
-		fmt.Printf("critical: %v - expired %v minutes ago\n", plugin.URL, -minutesUntil)
-		return sensu.CheckStateCritical, nil
+		fmt.Printf("critical: %v - expired %v minutes ago\n", source, -minutesUntil)
+		return sensu.CheckStateCritical
 	}
 	if minutesUntil < plugin.Critical {
-		fmt.Printf("critical: %v - %v minutes left, next update at %v\n", plugin.URL, minutesUntil, crl.NextUpdate)
-		return sensu.CheckStateCritical, nil
+		fmt.Printf("critical: %v - %v minutes left, next update at %v\n", source, minutesUntil, crl.NextUpdate)
+		return sensu.CheckStateCritical
 	}
 	if minutesUntil < plugin.Warning {
-		fmt.Printf("warning: %v - %v minutes left, next update at %v\n", plugin.URL, minutesUntil, crl.NextUpdate)
-		return sensu.CheckStateWarning, nil
+		fmt.Printf("warning: %v - %v minutes left, next update at %v\n", source, minutesUntil, crl.NextUpdate)
+		return sensu.CheckStateWarning
 	}
-	fmt.Printf("ok: %v - %v minutes left, next update at %v\n", plugin.URL, minutesUntil, crl.NextUpdate)
-	return sensu.CheckStateOK, nil
+	fmt.Printf("ok: %v - %v minutes left, next update at %v\n", source, minutesUntil, crl.NextUpdate)
+	return sensu.CheckStateOK
 }
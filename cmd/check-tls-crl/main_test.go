@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+	"fmt"
 	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -31,7 +38,7 @@ func TestCheckArgs(t *testing.T) {
 			config:      Config{Critical: 300, Warning: 600},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
-			errContains: "--url is required",
+			errContains: "--url is required unless --cert, --pkcs12 or --host is given",
 		},
 		{
 			name:        "missing critical",
@@ -60,6 +67,25 @@ func TestCheckArgs(t *testing.T) {
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
 		},
+		{
+			name:        "serial with certificate",
+			config:      Config{URL: "http://example.com/crl", Critical: 300, Warning: 600, Serial: "1234", Cert: "/tmp/cert.pem"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "only one of --cert, --pkcs12, --host and --serial",
+		},
+		{
+			name:        "this-update warning greater than critical",
+			config:      Config{URL: "http://example.com/crl", Critical: 300, Warning: 600, ThisUpdateWarning: 600, ThisUpdateCritical: 300},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--this-update-warning cannot be greater than --this-update-critical",
+		},
+		{
+			name:       "certificate without url is valid",
+			config:     Config{Cert: "/tmp/cert.pem", Critical: 300, Warning: 600},
+			wantStatus: sensu.CheckStateOK,
+		},
 		{
 			name:       "warning equals critical is valid",
 			config:     Config{URL: "http://example.com/crl", Critical: 300, Warning: 300},
@@ -88,8 +114,8 @@ func TestCheckArgs(t *testing.T) {
 	}
 }
 
-// TestFetchCRL tests fetching a CRL from a file path and via HTTP.
-func TestFetchCRL(t *testing.T) {
+// TestFetch tests fetching a CRL from a file path and via HTTP.
+func TestFetch(t *testing.T) {
 	crlData := generateCRL(t, time.Now().Add(24*time.Hour))
 
 	t.Run("from file", func(t *testing.T) {
@@ -101,13 +127,12 @@ func TestFetchCRL(t *testing.T) {
 		_ = f.Close()
 		defer func() { _ = os.Remove(f.Name()) }()
 
-		plugin = Config{URL: f.Name()}
-		data, err := fetchCRL()
+		data, err := fetch(f.Name())
 		if err != nil {
-			t.Fatalf("fetchCRL() unexpected error: %v", err)
+			t.Fatalf("fetch() unexpected error: %v", err)
 		}
 		if len(data) == 0 {
-			t.Error("fetchCRL() returned empty data")
+			t.Error("fetch() returned empty data")
 		}
 	})
 
@@ -118,21 +143,19 @@ func TestFetchCRL(t *testing.T) {
 		}))
 		defer srv.Close()
 
-		plugin = Config{URL: srv.URL + "/crl"}
-		data, err := fetchCRL()
+		data, err := fetch(srv.URL + "/crl")
 		if err != nil {
-			t.Fatalf("fetchCRL() unexpected error: %v", err)
+			t.Fatalf("fetch() unexpected error: %v", err)
 		}
 		if len(data) == 0 {
-			t.Error("fetchCRL() returned empty data")
+			t.Error("fetch() returned empty data")
 		}
 	})
 
 	t.Run("nonexistent file", func(t *testing.T) {
-		plugin = Config{URL: "/nonexistent/crl.crl"}
-		_, err := fetchCRL()
+		_, err := fetch("/nonexistent/crl.crl")
 		if err == nil {
-			t.Error("fetchCRL() expected error for nonexistent file")
+			t.Error("fetch() expected error for nonexistent file")
 		}
 	})
 
@@ -142,10 +165,25 @@ func TestFetchCRL(t *testing.T) {
 		}))
 		defer srv.Close()
 
-		plugin = Config{URL: srv.URL}
-		_, err := fetchCRL()
+		_, err := fetch(srv.URL)
 		if err == nil {
-			t.Error("fetchCRL() expected error for HTTP 500")
+			t.Error("fetch() expected error for HTTP 500")
+		}
+	})
+
+	t.Run("HTTP timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer srv.Close()
+		defer close(done)
+
+		plugin.Timeout = 1
+		defer func() { plugin.Timeout = 0 }()
+		_, err := fetch(srv.URL)
+		if err == nil || !strings.Contains(err.Error(), srv.URL) {
+			t.Errorf("fetch() error = %v, want a timeout naming %v", err, srv.URL)
 		}
 	})
 }
@@ -209,10 +247,622 @@ func TestExecuteCheck(t *testing.T) {
 	})
 }
 
+// TestVerifyIssuer tests CRL issuer DN, AKI and signature checks.
+func TestVerifyIssuer(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", []byte{1, 2, 3, 4})
+	otherCA, _ := generateCA(t, "Other CA", nil)
+	sameNameCA, _ := generateCA(t, "Test CA", nil)
+	impostorCA, _ := generateCA(t, "Test CA", []byte{1, 2, 3, 4})
+
+	crl, err := x509.ParseRevocationList(signCRL(t, ca, caKey, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(24 * time.Hour),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		issuer      *x509.Certificate
+		errContains string
+	}{
+		{"issuing CA", ca, ""},
+		{"different DN", otherCA, "does not match issuer certificate subject"},
+		{"different key identifier", sameNameCA, "authority key identifier"},
+		{"same name and key identifier, different key", impostorCA, "signature does not verify"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyIssuer(crl, tt.issuer)
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("verifyIssuer() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("verifyIssuer() error = %v, want to contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckIssuerCert tests --issuer-cert from PEM and DER files and over HTTP.
+func TestExecuteCheckIssuerCert(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	otherCA, _ := generateCA(t, "Other CA", nil)
+	crlFile := writeTempFile(t, "test-*.crl", signCRL(t, ca, caKey, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(24 * time.Hour),
+	}))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(ca.Raw)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		issuerCert string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"PEM file", writeTempFile(t, "ca-*.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})), sensu.CheckStateOK, false},
+		{"DER file", writeTempFile(t, "ca-*.der", ca.Raw), sensu.CheckStateOK, false},
+		{"URL", srv.URL + "/ca.crt", sensu.CheckStateOK, false},
+		{"wrong CA", writeTempFile(t, "other-*.der", otherCA.Raw), sensu.CheckStateCritical, true},
+		{"not a certificate", writeTempFile(t, "bad-*.pem", []byte("garbage")), sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{URL: crlFile, IssuerCert: tt.issuerCert, Critical: 300, Warning: 600}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestParseSerial tests hexadecimal serial number parsing.
+func TestParseSerial(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"1a2b", 0x1a2b, false},
+		{"1A:2B", 0x1a2b, false},
+		{"0x1A2B", 0x1a2b, false},
+		{"xyz", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSerial(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSerial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Int64() != tt.want {
+				t.Errorf("parseSerial() = %X, want %X", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckRevoked tests looking up a serial, certificate file or live
+// host certificate in the CRL.
+func TestExecuteCheckRevoked(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	revokedCert, revokedKey := generateLeaf(t, ca, caKey, 0x1234)
+	goodCert, _ := generateLeaf(t, ca, caKey, 0x5678)
+	crlFile := writeTempFile(t, "test-*.crl", signCRL(t, ca, caKey, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(24 * time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(0x1234), RevocationTime: time.Now().Add(-2 * time.Hour), ReasonCode: 1},
+		},
+	}))
+
+	tlsCert := tls.Certificate{Certificate: [][]byte{revokedCert.Raw}, PrivateKey: revokedKey}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name       string
+		config     Config
+		wantStatus int
+		wantErr    bool
+	}{
+		{"revoked serial", Config{Serial: "12:34"}, sensu.CheckStateCritical, false},
+		{"unrevoked serial", Config{Serial: "5678"}, sensu.CheckStateOK, false},
+		{"revoked certificate file", Config{Cert: writeTempFile(t, "cert-*.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: revokedCert.Raw}))}, sensu.CheckStateCritical, false},
+		{"unrevoked certificate file", Config{Cert: writeTempFile(t, "cert-*.der", goodCert.Raw)}, sensu.CheckStateOK, false},
+		{"revoked host certificate", Config{Host: l.Addr().String(), Timeout: 5}, sensu.CheckStateCritical, false},
+		{"revoked PBES2 PKCS#12 with CA chain", Config{PKCS12: filepath.Join("testdata", "pbes2.p12"), PKCS12Pass: "changeit"}, sensu.CheckStateCritical, false},
+		{"revoked legacy PKCS#12 with CA chain", Config{PKCS12: filepath.Join("testdata", "legacy.p12"), PKCS12Pass: "changeit"}, sensu.CheckStateCritical, false},
+		{"wrong PKCS#12 password", Config{PKCS12: filepath.Join("testdata", "pbes2.p12"), PKCS12Pass: "wrong"}, sensu.CheckStateCritical, true},
+		{"missing PKCS#12 file", Config{PKCS12: "/nonexistent/cert.p12", PKCS12Pass: "pass"}, sensu.CheckStateCritical, true},
+		{"invalid serial", Config{Serial: "not-hex"}, sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = tt.config
+			plugin.URL, plugin.Critical, plugin.Warning = crlFile, 300, 600
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckDistributionPoints tests CRL discovery from a certificate's
+// CRL Distribution Points.
+func TestExecuteCheckDistributionPoints(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	crlFor := func(nextUpdate time.Time) []byte {
+		return signCRL(t, ca, caKey, &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: nextUpdate.Add(-48 * time.Hour),
+			NextUpdate: nextUpdate,
+		})
+	}
+	current, expired := crlFor(time.Now().Add(24*time.Hour)), crlFor(time.Now().Add(-time.Hour))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/current.crl", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(current) })
+	mux.HandleFunc("/expired.crl", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(expired) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		points     []string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"single current point", []string{srv.URL + "/current.crl"}, sensu.CheckStateOK, false},
+		{"ldap point skipped", []string{"ldap://ldap.example.com/cn=Test%20CA?certificateRevocationList", srv.URL + "/current.crl"}, sensu.CheckStateOK, false},
+		{"one expired point", []string{srv.URL + "/current.crl", srv.URL + "/expired.crl"}, sensu.CheckStateCritical, false},
+		{"one unreachable point", []string{srv.URL + "/current.crl", srv.URL + "/missing.crl"}, sensu.CheckStateCritical, false},
+		{"no points", nil, sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, _ := generateLeaf(t, ca, caKey, 0x42, tt.points...)
+			plugin = Config{
+				Cert:     writeTempFile(t, "cert-*.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+				Critical: 300,
+				Warning:  600,
+			}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestParseCRLs tests DER, PEM and gzip CRL decoding.
+func TestParseCRLs(t *testing.T) {
+	der := generateCRL(t, time.Now().Add(24*time.Hour))
+	other := generateCRL(t, time.Now().Add(48*time.Hour))
+	pemCRL := func(ders ...[]byte) []byte {
+		var out []byte
+		for _, d := range ders {
+			out = append(out, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: d})...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantCount   int
+		errContains string
+	}{
+		{"DER", der, 1, ""},
+		{"PEM", pemCRL(der), 1, ""},
+		{"PEM bundle", pemCRL(der, other), 2, ""},
+		{"PEM with other blocks", append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0}}), pemCRL(der)...), 1, ""},
+		{"gzip DER", gzipData(t, der), 1, ""},
+		{"gzip PEM bundle", gzipData(t, pemCRL(der, other)), 2, ""},
+		{"garbage", []byte("not a crl"), 0, "as DER"},
+		{"PEM without CRL blocks", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0}}), 0, "as PEM"},
+		{"corrupt PEM block", pemCRL([]byte("junk")), 0, "PEM CRL block 1"},
+		{"corrupt gzip", append([]byte{0x1f, 0x8b}, "junk"...), 0, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crls, err := parseCRLs(tt.data, "test")
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("parseCRLs() error = %v, want to contain %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCRLs() unexpected error: %v", err)
+			}
+			if len(crls) != tt.wantCount {
+				t.Errorf("parseCRLs() returned %d CRLs, want %d", len(crls), tt.wantCount)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckPEMBundle tests that every CRL in a gzip-compressed PEM
+// bundle served with an unhelpful content type is checked.
+func TestExecuteCheckPEMBundle(t *testing.T) {
+	current := generateCRL(t, time.Now().Add(24*time.Hour))
+	expired := generateCRL(t, time.Now().Add(-time.Hour))
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: current}), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: expired})...)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(gzipData(t, bundle))
+	}))
+	defer srv.Close()
+
+	plugin = Config{URL: srv.URL + "/bundle.crl.gz", Critical: 300, Warning: 600}
+	status, err := executeCheck(nil)
+	if err != nil {
+		t.Fatalf("executeCheck() unexpected error: %v", err)
+	}
+	if status != sensu.CheckStateCritical {
+		t.Errorf("executeCheck() status = %v, want %v", status, sensu.CheckStateCritical)
+	}
+}
+
+// TestCRLNumbers tests CRL number tracking and the state file round trip.
+func TestCRLNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	numbers, err := loadCRLNumbers(path)
+	if err != nil {
+		t.Fatalf("loadCRLNumbers() missing file: unexpected error: %v", err)
+	}
+
+	steps := []struct {
+		number     int64
+		wantStatus int
+	}{
+		{5, sensu.CheckStateOK},
+		{5, sensu.CheckStateOK},
+		{6, sensu.CheckStateOK},
+		{4, sensu.CheckStateCritical},
+		{5, sensu.CheckStateCritical},
+	}
+	for _, step := range steps {
+		crl := &x509.RevocationList{Number: big.NewInt(step.number)}
+		if status := numbers.check("http://example.com/crl", crl, "test"); status != step.wantStatus {
+			t.Errorf("check(%d) = %v, want %v", step.number, status, step.wantStatus)
+		}
+	}
+
+	if err := numbers.save(path); err != nil {
+		t.Fatalf("save() unexpected error: %v", err)
+	}
+	loaded, err := loadCRLNumbers(path)
+	if err != nil {
+		t.Fatalf("loadCRLNumbers() unexpected error: %v", err)
+	}
+	if got := loaded["http://example.com/crl"]; got == nil || got.Int64() != 6 {
+		t.Errorf("loaded number = %v, want 6", got)
+	}
+
+	// Another check sharing the file saves what it loaded before this run:
+	// its own CRL is added and the newer number above is kept.
+	other := crlNumbers{"http://example.com/crl": big.NewInt(5), "http://example.org/crl": big.NewInt(2)}
+	if err := other.save(path); err != nil {
+		t.Fatalf("save() unexpected error: %v", err)
+	}
+	if loaded, err = loadCRLNumbers(path); err != nil {
+		t.Fatalf("loadCRLNumbers() unexpected error: %v", err)
+	}
+	if len(loaded) != 2 || loaded["http://example.com/crl"].Int64() != 6 || loaded["http://example.org/crl"].Int64() != 2 {
+		t.Errorf("merged state = %v, want example.com at 6 and example.org at 2", loaded)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCRLNumbers(path); err == nil {
+		t.Error("loadCRLNumbers() expected error for corrupt state file")
+	}
+}
+
+// TestExecuteCheckCRLNumberRollback tests that a CRL with a lower number than
+// the previous run is critical.
+func TestExecuteCheckCRLNumberRollback(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	var current []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(current)
+	}))
+	defer srv.Close()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	for _, run := range []struct {
+		number     int64
+		wantStatus int
+	}{
+		{5, sensu.CheckStateOK},
+		{6, sensu.CheckStateOK},
+		{3, sensu.CheckStateCritical},
+	} {
+		current = signCRL(t, ca, caKey, &x509.RevocationList{
+			Number:     big.NewInt(run.number),
+			ThisUpdate: time.Now().Add(-time.Hour),
+			NextUpdate: time.Now().Add(24 * time.Hour),
+		})
+		plugin = Config{URL: srv.URL + "/ca.crl", StateFile: stateFile, Critical: 300, Warning: 600}
+		status, err := executeCheck(nil)
+		if err != nil {
+			t.Fatalf("executeCheck() unexpected error: %v", err)
+		}
+		if status != run.wantStatus {
+			t.Errorf("executeCheck() CRL number %d status = %v, want %v", run.number, status, run.wantStatus)
+		}
+	}
+}
+
+// TestExecuteCheckBundleCRLNumbers tests that CRLs from one issuer in the same
+// bundle, such as the partitions of a partitioned CRL, are tracked apart.
+func TestExecuteCheckBundleCRLNumbers(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	var bundle []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer srv.Close()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	for _, run := range []struct {
+		numbers    [2]int64
+		wantStatus int
+	}{
+		{[2]int64{10, 3}, sensu.CheckStateOK},
+		{[2]int64{11, 4}, sensu.CheckStateOK},
+		{[2]int64{11, 2}, sensu.CheckStateCritical},
+	} {
+		bundle = nil
+		for _, number := range run.numbers {
+			der := signCRL(t, ca, caKey, &x509.RevocationList{
+				Number:     big.NewInt(number),
+				ThisUpdate: time.Now().Add(-time.Hour),
+				NextUpdate: time.Now().Add(24 * time.Hour),
+			})
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})...)
+		}
+		plugin = Config{URL: srv.URL + "/bundle.crl", StateFile: stateFile, Critical: 300, Warning: 600}
+		status, err := executeCheck(nil)
+		if err != nil {
+			t.Fatalf("executeCheck() unexpected error: %v", err)
+		}
+		if status != run.wantStatus {
+			t.Errorf("executeCheck() CRL numbers %v status = %v, want %v", run.numbers, status, run.wantStatus)
+		}
+	}
+}
+
+// TestExecuteCheckDeltaCRL tests following a base CRL's Freshest CRL extension
+// to its delta CRL.
+func TestExecuteCheckDeltaCRL(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	otherCA, otherKey := generateCA(t, "Other CA", nil)
+	var delta []byte
+	mux := http.NewServeMux()
+	mux.HandleFunc("/delta.crl", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(delta) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	freshest, err := asn1.Marshal([]distributionPoint{{
+		DistributionPoint: distributionPointName{FullName: []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: generalNameURI, Bytes: []byte(srv.URL + "/delta.crl")}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := signCRL(t, ca, caKey, &x509.RevocationList{
+		Number:          big.NewInt(5),
+		ThisUpdate:      time.Now().Add(-time.Hour),
+		NextUpdate:      time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidFreshestCRL, Value: freshest}},
+	})
+	baseFile := writeTempFile(t, "base-*.crl", base)
+	heldBaseFile := writeTempFile(t, "held-*.crl", signCRL(t, ca, caKey, &x509.RevocationList{
+		Number:                    big.NewInt(5),
+		ThisUpdate:                time.Now().Add(-time.Hour),
+		NextUpdate:                time.Now().Add(24 * time.Hour),
+		ExtraExtensions:           []pkix.Extension{{Id: oidFreshestCRL, Value: freshest}},
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(0x42), RevocationTime: time.Now().Add(-time.Hour), ReasonCode: reasonCertificateHold}},
+	}))
+
+	deltaCRL := func(issuer *x509.Certificate, key *rsa.PrivateKey, baseNumber int64, nextUpdate time.Duration, revoked ...x509.RevocationListEntry) []byte {
+		template := &x509.RevocationList{
+			Number:                    big.NewInt(6),
+			ThisUpdate:                time.Now().Add(-time.Hour),
+			NextUpdate:                time.Now().Add(nextUpdate),
+			RevokedCertificateEntries: revoked,
+		}
+		if baseNumber > 0 {
+			indicator, err := asn1.Marshal(big.NewInt(baseNumber))
+			if err != nil {
+				t.Fatal(err)
+			}
+			template.ExtraExtensions = []pkix.Extension{{Id: oidDeltaCRLIndicator, Critical: true, Value: indicator}}
+		}
+		return signCRL(t, issuer, key, template)
+	}
+	revokedEntry := x509.RevocationListEntry{SerialNumber: big.NewInt(0x42), RevocationTime: time.Now().Add(-time.Minute), ReasonCode: 1}
+	removedEntry := x509.RevocationListEntry{SerialNumber: big.NewInt(0x42), RevocationTime: time.Now().Add(-time.Minute), ReasonCode: reasonRemoveFromCRL}
+
+	tests := []struct {
+		name       string
+		base       string
+		delta      []byte
+		serial     string
+		wantStatus int
+	}{
+		{"current delta", baseFile, deltaCRL(ca, caKey, 5, 24*time.Hour), "", sensu.CheckStateOK},
+		{"serial revoked in delta", baseFile, deltaCRL(ca, caKey, 5, 24*time.Hour, revokedEntry), "42", sensu.CheckStateCritical},
+		{"serial not revoked", baseFile, deltaCRL(ca, caKey, 5, 24*time.Hour), "42", sensu.CheckStateOK},
+		{"serial removed in delta", baseFile, deltaCRL(ca, caKey, 5, 24*time.Hour, removedEntry), "42", sensu.CheckStateOK},
+		{"hold released by delta", heldBaseFile, deltaCRL(ca, caKey, 5, 24*time.Hour, removedEntry), "42", sensu.CheckStateOK},
+		{"hold still in place", heldBaseFile, deltaCRL(ca, caKey, 5, 24*time.Hour), "42", sensu.CheckStateCritical},
+		{"expired delta", baseFile, deltaCRL(ca, caKey, 5, -time.Minute), "", sensu.CheckStateCritical},
+		{"delta needs newer base", baseFile, deltaCRL(ca, caKey, 7, 24*time.Hour), "", sensu.CheckStateCritical},
+		{"complete CRL instead of delta", baseFile, deltaCRL(ca, caKey, 0, 24*time.Hour), "", sensu.CheckStateCritical},
+		{"delta from other issuer", baseFile, deltaCRL(otherCA, otherKey, 5, 24*time.Hour), "", sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta = tt.delta
+			plugin = Config{URL: tt.base, Serial: tt.serial, Critical: 300, Warning: 600}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("executeCheck() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckThisUpdate tests the ThisUpdate age thresholds.
+func TestExecuteCheckThisUpdate(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	crlFile := writeTempFile(t, "test-*.crl", signCRL(t, ca, caKey, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-3 * time.Hour),
+		NextUpdate: time.Now().Add(24 * time.Hour),
+	}))
+
+	tests := []struct {
+		name       string
+		warning    int
+		critical   int
+		wantStatus int
+	}{
+		{"disabled", 0, 0, sensu.CheckStateOK},
+		{"within thresholds", 240, 480, sensu.CheckStateOK},
+		{"older than warning", 60, 240, sensu.CheckStateWarning},
+		{"older than critical", 60, 120, sensu.CheckStateCritical},
+		{"critical only", 0, 120, sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{URL: crlFile, Critical: 300, Warning: 600, ThisUpdateWarning: tt.warning, ThisUpdateCritical: tt.critical}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("executeCheck() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestCRLMetrics tests the performance data recorded for a checked CRL.
+func TestCRLMetrics(t *testing.T) {
+	ca, caKey := generateCA(t, "Test CA", nil)
+	crlData := signCRL(t, ca, caKey, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(90 * time.Minute),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(1), RevocationTime: time.Now().Add(-2 * time.Hour)},
+			{SerialNumber: big.NewInt(2), RevocationTime: time.Now().Add(-2 * time.Hour)},
+		},
+	})
+	crlFile := writeTempFile(t, "test-*.crl", crlData)
+
+	plugin = Config{Critical: 30, Warning: 60}
+	check := &crlCheck{}
+	if _, err := check.checkLocation(crlFile); err != nil {
+		t.Fatalf("checkLocation() unexpected error: %v", err)
+	}
+	perfdata := check.perfdata()
+	for _, want := range []string{
+		"crl_revoked_entries=2",
+		fmt.Sprintf("crl_size=%dB", len(crlData)),
+		"crl_next_update_minutes=89",
+		"crl_fetch_latency=",
+	} {
+		if !strings.Contains(perfdata, want) {
+			t.Errorf("perfdata() = %q, want to contain %q", perfdata, want)
+		}
+	}
+
+	otherCA, _ := generateCA(t, "Other CA", nil)
+	check = &crlCheck{issuer: otherCA}
+	if _, err := check.checkLocation(crlFile); err == nil {
+		t.Fatal("checkLocation() expected error for the wrong issuer")
+	}
+	if perfdata := check.perfdata(); !strings.Contains(perfdata, "crl_fetch_latency=") {
+		t.Errorf("perfdata() after a failed check = %q, want the fetch metrics", perfdata)
+	}
+
+	check = &crlCheck{}
+	check.addMetric("http://a.example.com/ca.crl", "crl_revoked_entries", 1, "")
+	check.addMetric("http://b.example.com/ca.crl", "crl_revoked_entries", 2, "")
+	want := "crl_revoked_entries_http_a.example.com_ca.crl=1 crl_revoked_entries_http_b.example.com_ca.crl=2"
+	if got := check.perfdata(); got != want {
+		t.Errorf("perfdata() = %q, want %q", got, want)
+	}
+}
+
 // generateCRL creates a minimal DER-encoded CRL with the given NextUpdate time.
 // ThisUpdate is set to one hour before NextUpdate so the constraint ThisUpdate <= NextUpdate
 // is always satisfied, even when NextUpdate is in the past.
 func generateCRL(t *testing.T, nextUpdate time.Time) []byte {
+	t.Helper()
+	caCert, priv := generateCA(t, "Test CA", nil)
+	return signCRL(t, caCert, priv, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: nextUpdate.Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	})
+}
+
+// generateCA creates a self-signed CA certificate. A nil subjectKeyID lets
+// x509.CreateCertificate derive one from the public key.
+func generateCA(t *testing.T, org string, subjectKeyID []byte) (*x509.Certificate, *rsa.PrivateKey) {
 	t.Helper()
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -220,12 +870,13 @@ func generateCRL(t *testing.T, nextUpdate time.Time) []byte {
 	}
 	caTemplate := &x509.Certificate{
 		SerialNumber:          big.NewInt(1),
-		Subject:               pkix.Name{Organization: []string{"Test CA"}},
+		Subject:               pkix.Name{Organization: []string{org}},
 		NotBefore:             time.Now().Add(-24 * time.Hour),
 		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
 		KeyUsage:              x509.KeyUsageCRLSign | x509.KeyUsageCertSign,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
+		SubjectKeyId:          subjectKeyID,
 	}
 	caCertDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &priv.PublicKey, priv)
 	if err != nil {
@@ -235,19 +886,70 @@ func generateCRL(t *testing.T, nextUpdate time.Time) []byte {
 	if err != nil {
 		t.Fatal(err)
 	}
+	return caCert, priv
+}
 
-	thisUpdate := nextUpdate.Add(-time.Hour)
-	crlTemplate := &x509.RevocationList{
-		Number:     big.NewInt(1),
-		ThisUpdate: thisUpdate,
-		NextUpdate: nextUpdate,
-	}
-	crlDER, err := x509.CreateRevocationList(rand.Reader, crlTemplate, caCert, priv)
+// signCRL creates a DER-encoded CRL from template issued by caCert.
+func signCRL(t *testing.T, caCert *x509.Certificate, priv *rsa.PrivateKey, template *x509.RevocationList) []byte {
+	t.Helper()
+	crlDER, err := x509.CreateRevocationList(rand.Reader, template, caCert, priv)
 	if err != nil {
 		t.Fatal(err)
 	}
 	return crlDER
 }
 
-// suppress unused import warning for pem
-var _ = pem.EncodeToMemory
+// generateLeaf creates a server certificate for 127.0.0.1 with the given serial
+// and CRL distribution points, issued by caCert.
+func generateLeaf(t *testing.T, caCert *x509.Certificate, caKey *rsa.PrivateKey, serial int64, crlDistributionPoints ...string) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		CRLDistributionPoints: crlDistributionPoints,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &priv.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, priv
+}
+
+// gzipData compresses data with gzip.
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTempFile writes data to a temporary file removed when the test ends.
+func writeTempFile(t *testing.T, pattern string, data []byte) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write(data)
+	_ = f.Close()
+	return f.Name()
+}
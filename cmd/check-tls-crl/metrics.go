@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// metric is one Nagios performance data value reported for a CRL source.
+type metric struct {
+	source string
+	label  string
+	value  string
+}
+
+// invalidLabelChars matches what cannot appear in a performance data label.
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// addMetric records a value for source. uom is the Nagios unit of measure.
+func (c *crlCheck) addMetric(source, label string, value any, uom string) {
+	c.metrics = append(c.metrics, metric{source: source, label: label, value: fmt.Sprint(value) + uom})
+}
+
+// fetchTimed fetches location and records the payload size and fetch latency
+// under source.
+func (c *crlCheck) fetchTimed(location, source string) ([]byte, error) {
+	start := time.Now()
+	data, err := fetch(location)
+	if err != nil {
+		return nil, err
+	}
+	c.addMetric(source, "crl_size", len(data), "B")
+	c.addMetric(source, "crl_fetch_latency", fmt.Sprintf("%.3f", time.Since(start).Seconds()), "s")
+	return data, nil
+}
+
+// perfdata formats the recorded metrics as Nagios performance data. Labels
+// carry the source as a suffix when more than one CRL source was checked.
+func (c *crlCheck) perfdata() string {
+	sources := map[string]bool{}
+	for _, m := range c.metrics {
+		sources[m.source] = true
+	}
+	fields := make([]string, 0, len(c.metrics))
+	for _, m := range c.metrics {
+		label := m.label
+		if len(sources) > 1 {
+			label += "_" + strings.Trim(invalidLabelChars.ReplaceAllString(m.source, "_"), "_")
+		}
+		fields = append(fields, label+"="+m.value)
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/statefile"
+)
+
+// crlNumbers records the highest CRL number seen per CRL location.
+type crlNumbers map[string]*big.Int
+
+// loadCRLNumbers reads the state file at path. A missing file is an empty state.
+func loadCRLNumbers(path string) (crlNumbers, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return crlNumbers{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %v", err)
+	}
+	numbers := crlNumbers{}
+	if err := json.Unmarshal(data, &numbers); err != nil {
+		return nil, fmt.Errorf("cannot parse state file %v: %v", path, err)
+	}
+	return numbers, nil
+}
+
+// save records n in the state file at path. Other checks may have updated the
+// file since it was loaded, so it is reloaded under its lock and the highest
+// number is kept per key before the new state replaces the old.
+func (n crlNumbers) save(path string) error {
+	unlock, err := statefile.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := loadCRLNumbers(path)
+	if err != nil {
+		return err
+	}
+	for key, number := range n {
+		if last, ok := current[key]; !ok || number.Cmp(last) > 0 {
+			current[key] = number
+		}
+	}
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state file: %v", err)
+	}
+	if err := statefile.WriteFile(path, data); err != nil {
+		return fmt.Errorf("writing state file: %v", err)
+	}
+	return nil
+}
+
+// check compares crl's number with the one last seen under key. A lower
+// number means a replayed or rolled-back CRL and is critical; the highest
+// number stays recorded so the alert persists until a newer CRL appears.
+func (n crlNumbers) check(key string, crl *x509.RevocationList, source string) int {
+	if crl.Number == nil {
+		return sensu.CheckStateOK
+	}
+	if last, ok := n[key]; ok && crl.Number.Cmp(last) < 0 {
+		fmt.Printf("critical: %v - CRL number went backwards from %v to %v\n", source, last, crl.Number)
+		return sensu.CheckStateCritical
+	}
+	n[key] = crl.Number
+	return sensu.CheckStateOK
+}
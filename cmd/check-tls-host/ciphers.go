@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+)
+
+// cipherClasses are the keywords --ciphers-allow and --ciphers-deny accept
+// besides suite names, each matching a class of cipher suites.
+var cipherClasses = map[string]func(suite *tls.CipherSuite) bool{
+	"insecure": func(suite *tls.CipherSuite) bool { return suite.Insecure },
+	"cbc":      func(suite *tls.CipherSuite) bool { return strings.Contains(suite.Name, "_CBC_") },
+	"non-pfs":  func(suite *tls.CipherSuite) bool { return strings.HasPrefix(suite.Name, "TLS_RSA_") },
+}
+
+// cipherSuite returns the crypto/tls description of the suite id.
+func cipherSuite(id uint16) *tls.CipherSuite {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.ID == id {
+			return suite
+		}
+	}
+	return &tls.CipherSuite{ID: id, Name: tls.CipherSuiteName(id)}
+}
+
+// validateCipherPolicy checks that every entry of option is a class keyword
+// or the name of a cipher suite.
+func validateCipherPolicy(option string, entries []string) error {
+	for _, entry := range entries {
+		if _, ok := cipherClasses[strings.ToLower(entry)]; ok {
+			continue
+		}
+		known := false
+		for _, id := range allCipherSuites() {
+			if strings.EqualFold(entry, tls.CipherSuiteName(id)) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%v: unknown cipher suite %q (use a suite name or insecure, cbc, non-pfs)", option, entry)
+		}
+	}
+	return nil
+}
+
+// cipherMatches returns the entries of policy that match suite.
+func cipherMatches(policy []string, suite *tls.CipherSuite) []string {
+	var matched []string
+	for _, entry := range policy {
+		if class, ok := cipherClasses[strings.ToLower(entry)]; (ok && class(suite)) || strings.EqualFold(entry, suite.Name) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// cipherViolation explains why suite breaks the --ciphers-allow /
+// --ciphers-deny policy, or returns "" if it does not.
+func cipherViolation(suite *tls.CipherSuite) string {
+	if denied := cipherMatches(plugin.CiphersDeny, suite); len(denied) > 0 {
+		return fmt.Sprintf("denied by --ciphers-deny (%v)", strings.Join(denied, ", "))
+	}
+	if len(plugin.CiphersAllow) > 0 && len(cipherMatches(plugin.CiphersAllow, suite)) == 0 {
+		return "not in --ciphers-allow"
+	}
+	return ""
+}
+
+// acceptedCiphers offers each suite that can be used with version on its own
+// handshake and returns those the server accepts.
+func acceptedCiphers(version uint16, certificates []tls.Certificate, timeout time.Duration) ([]uint16, error) {
+	var accepted []uint16
+	for _, id := range allCipherSuites() {
+		if !slices.Contains(cipherSuite(id).SupportedVersions, version) {
+			continue
+		}
+		cfg := &tls.Config{MinVersion: version, MaxVersion: version, CipherSuites: []uint16{id}, Certificates: certificates}
+		_, ok, err := probeHandshake(cfg, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			accepted = append(accepted, id)
+		}
+	}
+	return accepted, nil
+}
+
+// preferenceOrder sorts the accepted suites in the order the server picks
+// them: each handshake offers the suites not yet picked, and the one the server
+// chooses is the next in its order.
+func preferenceOrder(version uint16, accepted []uint16, certificates []tls.Certificate, timeout time.Duration) ([]uint16, error) {
+	remaining := slices.Clone(accepted)
+	var order []uint16
+	for len(remaining) > 1 {
+		cfg := &tls.Config{MinVersion: version, MaxVersion: version, CipherSuites: remaining, Certificates: certificates}
+		state, ok, err := probeHandshake(cfg, timeout)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.Index(remaining, state.CipherSuite)
+		if !ok || i < 0 {
+			break
+		}
+		order = append(order, state.CipherSuite)
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+	return append(order, remaining...), nil
+}
+
+// TLS record and handshake message types needed to rewrite a ClientHello and
+// read the suite out of a ServerHello.
+const (
+	recordTypeHandshake    = 22
+	handshakeClientHello   = 1
+	handshakeServerHello   = 2
+	recordHeaderLength     = 5
+	handshakeHeaderLength  = 4
+	helloRandomOffset      = recordHeaderLength + handshakeHeaderLength + 2
+	helloSessionIDOffset   = helloRandomOffset + 32
+	maxServerHelloCaptured = 512
+)
+
+// reversedHelloConn reverses the cipher suite list of the first ClientHello
+// written through it and keeps the start of what the server sends back.
+// crypto/tls sends the offered suites in its own order whatever the order of
+// tls.Config.CipherSuites, so this is the only way to offer a different one.
+type reversedHelloConn struct {
+	net.Conn
+	hello    bool
+	received []byte
+}
+
+func (c *reversedHelloConn) Write(b []byte) (int, error) {
+	if c.hello {
+		return c.Conn.Write(b)
+	}
+	c.hello = true
+	rewritten := slices.Clone(b)
+	if suites := helloCipherSuites(rewritten); suites != nil {
+		for i, j := 0, len(suites)-2; i < j; i, j = i+2, j-2 {
+			suites[i], suites[i+1], suites[j], suites[j+1] = suites[j], suites[j+1], suites[i], suites[i+1]
+		}
+	}
+	if _, err := c.Conn.Write(rewritten); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *reversedHelloConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if room := maxServerHelloCaptured - len(c.received); room > 0 {
+		c.received = append(c.received, b[:min(n, room)]...)
+	}
+	return n, err
+}
+
+// helloCipherSuites returns the cipher suite list of the ClientHello record at
+// the start of record, or nil if it is not one.
+func helloCipherSuites(record []byte) []byte {
+	if len(record) <= helloSessionIDOffset || record[0] != recordTypeHandshake || record[recordHeaderLength] != handshakeClientHello {
+		return nil
+	}
+	offset := helloSessionIDOffset + 1 + int(record[helloSessionIDOffset])
+	if len(record) < offset+2 {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(record[offset:]))
+	offset += 2
+	if length%2 != 0 || len(record) < offset+length {
+		return nil
+	}
+	return record[offset : offset+length]
+}
+
+// serverHelloSuite returns the cipher suite picked by the ServerHello record
+// at the start of record.
+func serverHelloSuite(record []byte) (uint16, bool) {
+	if len(record) <= helloSessionIDOffset || record[0] != recordTypeHandshake || record[recordHeaderLength] != handshakeServerHello {
+		return 0, false
+	}
+	offset := helloSessionIDOffset + 1 + int(record[helloSessionIDOffset])
+	if len(record) < offset+2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(record[offset:]), true
+}
+
+// followsClientOrder offers the suites of order, the server's picks for
+// version, once more in reverse of the order crypto/tls sends them. A server
+// that enforces its own order picks order[0] again; one that follows the
+// client's preference picks from the other end.
+func followsClientOrder(version uint16, order []uint16, certificates []tls.Certificate, timeout time.Duration) (bool, error) {
+	if len(order) < 2 {
+		return false, nil
+	}
+	conn, _, err := dialServer(timeout)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	reversed := &reversedHelloConn{Conn: conn}
+	cfg := &tls.Config{MinVersion: version, MaxVersion: version, CipherSuites: order, Certificates: certificates, ServerName: plugin.Host, InsecureSkipVerify: true} //nolint:gosec
+	// The handshake fails once the server sees a transcript other than the
+	// one the client hashed; by then the ServerHello has been read.
+	_ = tls.Client(reversed, cfg).Handshake()
+	picked, ok := serverHelloSuite(reversed.received)
+	return ok && picked != order[0], nil
+}
+
+// checkCiphers enumerates the cipher suites the server accepts for each TLS
+// version, in its preference order, and reports every accepted suite that
+// breaks the --ciphers-allow / --ciphers-deny policy as critical. TLS 1.3
+// suites cannot be offered individually, so only the negotiated one is known.
+func checkCiphers(timeout time.Duration) (int, []string, error) {
+	if err := validateCipherPolicy("--ciphers-allow", plugin.CiphersAllow); err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+	if err := validateCipherPolicy("--ciphers-deny", plugin.CiphersDeny); err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+	certificates, err := clientCertificates()
+	if err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+
+	var lines, problems []string
+	total, versions := 0, 0
+	for _, version := range tlsVersions {
+		name := tls.VersionName(version)
+		var suites []uint16
+		if version == tls.VersionTLS13 {
+			cfg := &tls.Config{MinVersion: version, MaxVersion: version, Certificates: certificates}
+			state, ok, err := probeHandshake(cfg, timeout)
+			if err != nil {
+				return sensu.CheckStateCritical, nil, fmt.Errorf("probing %v cipher suites: %v", name, err)
+			}
+			if ok {
+				suites = []uint16{state.CipherSuite}
+				lines = append(lines, fmt.Sprintf("%v (negotiated): %v", name, tls.CipherSuiteName(state.CipherSuite)))
+			}
+		} else {
+			accepted, err := acceptedCiphers(version, certificates, timeout)
+			if err == nil && len(accepted) > 0 {
+				suites, err = preferenceOrder(version, accepted, certificates, timeout)
+			}
+			clientOrder := false
+			if err == nil {
+				clientOrder, err = followsClientOrder(version, suites, certificates, timeout)
+			}
+			if err != nil {
+				return sensu.CheckStateCritical, nil, fmt.Errorf("probing %v cipher suites: %v", name, err)
+			}
+			if len(suites) > 0 {
+				names := make([]string, len(suites))
+				for i, id := range suites {
+					names[i] = tls.CipherSuiteName(id)
+				}
+				order := "server order"
+				if clientOrder {
+					order = "client preference"
+				}
+				lines = append(lines, fmt.Sprintf("%v (%v): %v", name, order, strings.Join(names, ", ")))
+			}
+		}
+		total += len(suites)
+		if len(suites) > 0 {
+			versions++
+		}
+		for _, id := range suites {
+			if violation := cipherViolation(cipherSuite(id)); violation != "" {
+				problems = append(problems, fmt.Sprintf("critical: %v %v %v", name, tls.CipherSuiteName(id), violation))
+			}
+		}
+	}
+
+	state := sensu.CheckStateOK
+	if len(problems) > 0 {
+		state = sensu.CheckStateCritical
+	}
+	summary := fmt.Sprintf("%v: %v accepts %d cipher suites across %d TLS versions, %d against policy", expiry.StateLabel(state), plugin.Host, total, versions, len(problems))
+	return state, append(append([]string{summary}, lines...), problems...), nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// TestCipherViolation tests the default deny classes and the allowlist.
+func TestCipherViolation(t *testing.T) {
+	defaultDeny := []string{"insecure", "cbc", "non-pfs"}
+	tests := []struct {
+		name      string
+		suite     uint16
+		allow     []string
+		deny      []string
+		wantMatch bool
+	}{
+		{"pfs aead", tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, nil, defaultDeny, false},
+		{"cbc", tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, nil, defaultDeny, true},
+		{"rsa key exchange", tls.TLS_RSA_WITH_AES_128_GCM_SHA256, nil, defaultDeny, true},
+		{"insecure rc4", tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA, nil, defaultDeny, true},
+		{"tls 1.3 suite", tls.TLS_AES_256_GCM_SHA384, nil, defaultDeny, false},
+		{"denied by name", tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, nil, []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}, true},
+		{"not allowed", tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, []string{"tls_ecdhe_rsa_with_aes_128_gcm_sha256"}, nil, true},
+		{"allowed", tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, []string{"tls_ecdhe_rsa_with_aes_128_gcm_sha256"}, nil, false},
+		{"deny wins over allow", tls.TLS_RSA_WITH_AES_128_GCM_SHA256, []string{"TLS_RSA_WITH_AES_128_GCM_SHA256"}, defaultDeny, true},
+		{"no policy", tls.TLS_RSA_WITH_AES_128_CBC_SHA, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{CiphersAllow: tt.allow, CiphersDeny: tt.deny}
+			if got := cipherViolation(cipherSuite(tt.suite)); (got != "") != tt.wantMatch {
+				t.Errorf("cipherViolation(%v) = %q, want violation %v", tls.CipherSuiteName(tt.suite), got, tt.wantMatch)
+			}
+		})
+	}
+}
+
+// TestAcceptedCiphers tests that suites are enumerated one handshake at a time.
+func TestAcceptedCiphers(t *testing.T) {
+	want := []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+	host, port, cleanup := startProbeServer(t, &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: want})
+	defer cleanup()
+	plugin = Config{Host: host, Port: port}
+
+	accepted, err := acceptedCiphers(tls.VersionTLS12, nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("acceptedCiphers() unexpected error: %v", err)
+	}
+	if !sameSuites(accepted, want) {
+		t.Errorf("acceptedCiphers() = %v, want %v", accepted, want)
+	}
+	order, err := preferenceOrder(tls.VersionTLS12, accepted, nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("preferenceOrder() unexpected error: %v", err)
+	}
+	if !sameSuites(order, want) {
+		t.Errorf("preferenceOrder() = %v, want a permutation of %v", order, want)
+	}
+}
+
+// TestFollowsClientOrder tests telling a server that enforces its own suite
+// order from one that takes the client's first choice.
+func TestFollowsClientOrder(t *testing.T) {
+	suites := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+
+	t.Run("server order", func(t *testing.T) {
+		host, port, cleanup := startProbeServer(t, &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: suites})
+		defer cleanup()
+		plugin = Config{Host: host, Port: port}
+		order, err := preferenceOrder(tls.VersionTLS12, suites, nil, 5*time.Second)
+		if err != nil {
+			t.Fatalf("preferenceOrder() unexpected error: %v", err)
+		}
+		clientOrder, err := followsClientOrder(tls.VersionTLS12, order, nil, 5*time.Second)
+		if err != nil {
+			t.Fatalf("followsClientOrder() unexpected error: %v", err)
+		}
+		if clientOrder {
+			t.Error("followsClientOrder() = true, want false")
+		}
+	})
+
+	t.Run("client preference", func(t *testing.T) {
+		host, port, cleanup := startClientOrderServer(t)
+		defer cleanup()
+		plugin = Config{Host: host, Port: port}
+		clientOrder, err := followsClientOrder(tls.VersionTLS12, suites, nil, 5*time.Second)
+		if err != nil {
+			t.Fatalf("followsClientOrder() unexpected error: %v", err)
+		}
+		if !clientOrder {
+			t.Error("followsClientOrder() = false, want true")
+		}
+	})
+}
+
+// TestCheckCiphers tests the policy check against servers with restricted suites.
+func TestCheckCiphers(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxVersion uint16
+		suites     []uint16
+		allow      []string
+		wantStatus int
+	}{
+		{"modern suites", tls.VersionTLS13, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}, nil, sensu.CheckStateOK},
+		{"cbc suite", tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}, nil, sensu.CheckStateCritical},
+		{"rsa key exchange", tls.VersionTLS12, []uint16{tls.TLS_RSA_WITH_AES_256_GCM_SHA384}, nil, sensu.CheckStateCritical},
+		{"outside allowlist", tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, cleanup := startProbeServer(t, &tls.Config{MaxVersion: tt.maxVersion, CipherSuites: tt.suites})
+			defer cleanup()
+			plugin = Config{Host: host, Port: port, CiphersAllow: tt.allow, CiphersDeny: []string{"insecure", "cbc", "non-pfs"}}
+			status, _, err := checkCiphers(5 * time.Second)
+			if err != nil {
+				t.Fatalf("checkCiphers() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("checkCiphers() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// startClientOrderServer answers each ClientHello with a TLS 1.2 ServerHello
+// picking the first suite offered, then hangs up.
+func startClientOrderServer(t *testing.T) (host string, port int, cleanup func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer func() { _ = c.Close() }()
+				record := make([]byte, recordHeaderLength)
+				if _, err := io.ReadFull(c, record); err != nil {
+					return
+				}
+				record = append(record, make([]byte, binary.BigEndian.Uint16(record[3:]))...)
+				if _, err := io.ReadFull(c, record[recordHeaderLength:]); err != nil {
+					return
+				}
+				suites := helloCipherSuites(record)
+				if len(suites) < 2 {
+					return
+				}
+				hello := append([]byte{0x03, 0x03}, make([]byte, 32)...) // version, random
+				hello = append(hello, 0, suites[0], suites[1], 0)        // session ID, suite, compression
+				message := append([]byte{handshakeServerHello, 0, 0, byte(len(hello))}, hello...)
+				_, _ = c.Write(append([]byte{recordTypeHandshake, 0x03, 0x03, 0, byte(len(message))}, message...))
+			}(conn)
+		}
+	}()
+	return "127.0.0.1", l.Addr().(*net.TCPAddr).Port, func() { _ = l.Close() }
+}
+
+func sameSuites(got, want []uint16) bool {
+	got, want = slices.Clone(got), slices.Clone(want)
+	slices.Sort(got)
+	slices.Sort(want)
+	return slices.Equal(got, want)
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bufio"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -12,6 +12,11 @@ import (
 
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/certpolicy"
+	"github.com/nmollerup/sensu-check-tls/internal/certstate"
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+	"github.com/nmollerup/sensu-check-tls/internal/pins"
 )
 
 type Config struct {
@@ -19,14 +24,39 @@ type Config struct {
 	Host                    string
 	Port                    int
 	Address                 string
-	Warning                 int
-	Critical                int
+	Warning                 string
+	Critical                string
+	NotBeforeGrace          string
 	ClientCert              string
 	ClientKey               string
 	SkipHostnameVerification bool
 	SkipChainVerification    bool
+	ChainExpiry              bool
+	VerifiedPathExpiry       bool
 	InsecureSkipVerify       bool
+	TrustedCAFile            string
+	OCSP                     bool
+	OCSPURL                  string
+	OCSPStaple               bool
+	OCSPWarning              int
+	OCSPCritical             int
+	Protocols                bool
+	ProtocolsDeny            []string
+	ProtocolsRequire         []string
+	Ciphers                  bool
+	CiphersAllow             []string
+	CiphersDeny              []string
+	MinRSABits               int
+	ECDSACurves              []string
+	AllowWeakSignatures      bool
+	MaxValidity              string
+	VerifiedPathPolicy       bool
+	PinCert                  []string
+	PinSPKI                  []string
+	StateFile                string
+	ChangeState              string
 	StartTLS                 string
+	EHLOName                 string
 	Timeout                  int
 }
 
@@ -60,20 +90,26 @@ var (
 			Usage:     "TCP address to connect to (overrides host for connection, host still used for SNI/verification)",
 			Value:     &plugin.Address,
 		},
-		&sensu.PluginConfigOption[int]{
+		&sensu.PluginConfigOption[string]{
 			Argument:  "warning",
 			Shorthand: "w",
-			Default:   14,
-			Usage:     "Days before expiry to warn",
+			Default:   "14",
+			Usage:     "Expiry threshold to warn: days, a duration such as 36h, or a percentage of the validity period such as 20%",
 			Value:     &plugin.Warning,
 		},
-		&sensu.PluginConfigOption[int]{
+		&sensu.PluginConfigOption[string]{
 			Argument:  "critical",
 			Shorthand: "c",
-			Default:   7,
-			Usage:     "Days before expiry to go critical",
+			Default:   "7",
+			Usage:     "Expiry threshold to go critical: days, a duration such as 36h, or a percentage of the validity period such as 10%",
 			Value:     &plugin.Critical,
 		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "not-before-grace",
+			Default:  "0s",
+			Usage:    "How far in the future a certificate's NotBefore may be before it is critical, as a duration such as 5m",
+			Value:    &plugin.NotBeforeGrace,
+		},
 		&sensu.PluginConfigOption[string]{
 			Argument: "client-cert",
 			Default:  "",
@@ -98,19 +134,164 @@ var (
 			Usage:    "Disable certificate chain verification",
 			Value:    &plugin.SkipChainVerification,
 		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "chain-expiry",
+			Default:  false,
+			Usage:    "Apply expiry thresholds to every certificate presented by the server, not just the leaf",
+			Value:    &plugin.ChainExpiry,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "verified-path-expiry",
+			Default:  false,
+			Usage:    "Like --chain-expiry, but also include the trusted root of the verified path",
+			Value:    &plugin.VerifiedPathExpiry,
+		},
 		&sensu.PluginConfigOption[bool]{
 			Argument:  "insecure-skip-verify",
 			Shorthand: "i",
 			Default:   false,
-			Usage:     "Skip TLS certificate verification (not recommended)",
+			Usage:     "Skip hostname and chain verification (not recommended)",
 			Value:     &plugin.InsecureSkipVerify,
 		},
+		&sensu.PluginConfigOption[string]{
+			Argument:  "trusted-ca-file",
+			Shorthand: "t",
+			Default:   "",
+			Usage:     "TLS CA certificate bundle in PEM format used for chain verification (defaults to the system roots)",
+			Value:     &plugin.TrustedCAFile,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "ocsp",
+			Default:  false,
+			Usage:    "Check the revocation status of the server certificate with its OCSP responder",
+			Value:    &plugin.OCSP,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "ocsp-url",
+			Default:  "",
+			Usage:    "OCSP responder URL (overrides the URL in the certificate's AIA extension)",
+			Value:    &plugin.OCSPURL,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "ocsp-staple",
+			Default:  false,
+			Usage:    "Check the OCSP response stapled to the handshake (always checked for Must-Staple certificates)",
+			Value:    &plugin.OCSPStaple,
+		},
+		&sensu.PluginConfigOption[int]{
+			Argument: "ocsp-warning",
+			Default:  1440,
+			Usage:    "Minutes before the OCSP response's next update to warn",
+			Value:    &plugin.OCSPWarning,
+		},
+		&sensu.PluginConfigOption[int]{
+			Argument: "ocsp-critical",
+			Default:  360,
+			Usage:    "Minutes before the OCSP response's next update to go critical",
+			Value:    &plugin.OCSPCritical,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "protocols",
+			Default:  false,
+			Usage:    "Probe which TLS versions (1.0 to 1.3) the server accepts and check them against --protocols-deny and --protocols-require",
+			Value:    &plugin.Protocols,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Argument: "protocols-deny",
+			Default:  []string{"1.0", "1.1"},
+			Usage:    "TLS versions that are critical when accepted",
+			Value:    &plugin.ProtocolsDeny,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Argument: "protocols-require",
+			Default:  []string{"1.3"},
+			Usage:    "TLS versions that are a warning when not accepted",
+			Value:    &plugin.ProtocolsRequire,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "ciphers",
+			Default:  false,
+			Usage:    "Enumerate the cipher suites the server accepts for each TLS version and check them against --ciphers-allow and --ciphers-deny",
+			Value:    &plugin.Ciphers,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Argument: "ciphers-allow",
+			Default:  []string{},
+			Usage:    "Cipher suites (names or insecure, cbc, non-pfs) the server may accept; any other accepted suite is critical",
+			Value:    &plugin.CiphersAllow,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Argument: "ciphers-deny",
+			Default:  []string{"insecure", "cbc", "non-pfs"},
+			Usage:    "Cipher suites (names or insecure, cbc, non-pfs) that are critical when accepted",
+			Value:    &plugin.CiphersDeny,
+		},
+		&sensu.PluginConfigOption[int]{
+			Argument: "min-rsa-bits",
+			Default:  2048,
+			Usage:    "Minimum RSA key size in bits for every certificate checked (0 disables)",
+			Value:    &plugin.MinRSABits,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Argument: "ecdsa-curves",
+			Default:  []string{"P-256", "P-384", "P-521"},
+			Usage:    "ECDSA curves allowed for certificate keys",
+			Value:    &plugin.ECDSACurves,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "allow-weak-signatures",
+			Default:  false,
+			Usage:    "Accept SHA-1 and MD5 certificate signatures (always accepted on self-signed roots)",
+			Value:    &plugin.AllowWeakSignatures,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "max-validity",
+			Default:  "",
+			Usage:    "Maximum validity period of end-entity certificates, in days or as a duration (e.g. 398)",
+			Value:    &plugin.MaxValidity,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "verified-path-policy",
+			Default:  false,
+			Usage:    "Also apply the crypto policy to the trusted root of the verified path, not just the served chain",
+			Value:    &plugin.VerifiedPathPolicy,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Argument: "pin-cert",
+			Default:  []string{},
+			Usage:    "Expected SHA-256 fingerprint of the leaf or a chain certificate, in hex or base64 (can be repeated)",
+			Value:    &plugin.PinCert,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Argument: "pin-spki",
+			Default:  []string{},
+			Usage:    "Expected SHA-256 hash of the SubjectPublicKeyInfo of the leaf or a chain certificate, as sha256/base64 or hex (can be repeated)",
+			Value:    &plugin.PinSPKI,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "state-file",
+			Default:  "",
+			Usage:    "Path to a file recording the leaf certificate seen per host and port; a change since the last run is reported",
+			Value:    &plugin.StateFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "change-state",
+			Default:  "warning",
+			Usage:    "State for a leaf certificate change detected with --state-file: ok, warning or critical",
+			Value:    &plugin.ChangeState,
+		},
 		&sensu.PluginConfigOption[string]{
 			Argument: "starttls",
 			Default:  "",
-			Usage:    "Use STARTTLS for the given protocol before TLS handshake (smtp, imap)",
+			Usage:    "Use STARTTLS for the given protocol before TLS handshake (smtp, imap, pop3, ftp, ldap, xmpp, postgres, mysql)",
 			Value:    &plugin.StartTLS,
 		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "ehlo-name",
+			Default:  "",
+			Usage:    "Client name sent in the SMTP EHLO command (defaults to the local hostname)",
+			Value:    &plugin.EHLOName,
+		},
 		&sensu.PluginConfigOption[int]{
 			Argument: "timeout",
 			Default:  30,
@@ -129,104 +310,97 @@ func checkArgs(event *corev2.Event) (int, error) {
 	if len(plugin.Host) == 0 {
 		return sensu.CheckStateWarning, fmt.Errorf("--host is required")
 	}
-	if plugin.Warning <= plugin.Critical {
+	expiryPolicy, err := expiry.NewPolicy(plugin.Warning, plugin.Critical, plugin.NotBeforeGrace)
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	if !expiryPolicy.Critical.Less(expiryPolicy.Warning) {
 		return sensu.CheckStateWarning, fmt.Errorf("--warning must be greater than --critical")
 	}
-	if plugin.StartTLS != "" && plugin.StartTLS != "smtp" && plugin.StartTLS != "imap" {
-		return sensu.CheckStateWarning, fmt.Errorf("--starttls must be 'smtp' or 'imap'")
+	if _, ok := starttlsNegotiators[plugin.StartTLS]; plugin.StartTLS != "" && !ok {
+		return sensu.CheckStateWarning, fmt.Errorf("--starttls must be one of: %v", strings.Join(starttlsProtocols(), ", "))
 	}
-	return sensu.CheckStateOK, nil
-}
-
-func starttlsSMTP(conn net.Conn) error {
-	r := bufio.NewReader(conn)
-	line, err := r.ReadString('\n')
+	if plugin.OCSPWarning < plugin.OCSPCritical {
+		return sensu.CheckStateWarning, fmt.Errorf("--ocsp-warning cannot be less than --ocsp-critical")
+	}
+	denied, err := parseTLSVersions("--protocols-deny", plugin.ProtocolsDeny)
 	if err != nil {
-		return fmt.Errorf("reading SMTP banner: %v", err)
+		return sensu.CheckStateWarning, err
 	}
-	if !strings.HasPrefix(line, "220") {
-		return fmt.Errorf("expected SMTP 220 banner, got: %v", strings.TrimSpace(line))
+	required, err := parseTLSVersions("--protocols-require", plugin.ProtocolsRequire)
+	if err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	if _, err := fmt.Fprintf(conn, "STARTTLS\r\n"); err != nil {
-		return fmt.Errorf("sending STARTTLS: %v", err)
+	for version := range denied {
+		if required[version] {
+			return sensu.CheckStateWarning, fmt.Errorf("%v cannot be in both --protocols-deny and --protocols-require", tls.VersionName(version))
+		}
 	}
-	line, err = r.ReadString('\n')
-	if err != nil {
-		return fmt.Errorf("reading STARTTLS response: %v", err)
+	if err := validateCipherPolicy("--ciphers-allow", plugin.CiphersAllow); err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	if !strings.HasPrefix(line, "220") {
-		return fmt.Errorf("expected SMTP 220 after STARTTLS, got: %v", strings.TrimSpace(line))
+	if err := validateCipherPolicy("--ciphers-deny", plugin.CiphersDeny); err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	return nil
-}
-
-func starttlsIMAP(conn net.Conn) error {
-	r := bufio.NewReader(conn)
-	line, err := r.ReadString('\n')
-	if err != nil {
-		return fmt.Errorf("reading IMAP banner: %v", err)
+	if _, err := certpolicy.New(plugin.MinRSABits, plugin.ECDSACurves, plugin.AllowWeakSignatures, plugin.MaxValidity); err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	if !strings.HasPrefix(line, "* OK") {
-		return fmt.Errorf("expected IMAP '* OK' banner, got: %v", strings.TrimSpace(line))
+	if _, err := pins.ParseAll("--pin-cert", plugin.PinCert); err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	if _, err := fmt.Fprintf(conn, "a001 STARTTLS\r\n"); err != nil {
-		return fmt.Errorf("sending STARTTLS: %v", err)
+	if _, err := pins.ParseAll("--pin-spki", plugin.PinSPKI); err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	line, err = r.ReadString('\n')
-	if err != nil {
-		return fmt.Errorf("reading STARTTLS response: %v", err)
+	if _, ok := certstate.ChangeStates[plugin.ChangeState]; len(plugin.StateFile) > 0 && !ok {
+		return sensu.CheckStateWarning, fmt.Errorf("--change-state must be one of: ok, warning, critical")
+	}
+	if plugin.VerifiedPathExpiry && plugin.SkipChainVerification {
+		return sensu.CheckStateWarning, fmt.Errorf("--verified-path-expiry cannot be used with --skip-chain-verification")
+	}
+	if plugin.VerifiedPathPolicy && plugin.SkipChainVerification {
+		return sensu.CheckStateWarning, fmt.Errorf("--verified-path-policy cannot be used with --skip-chain-verification")
+	}
+	if plugin.VerifiedPathExpiry && plugin.InsecureSkipVerify {
+		return sensu.CheckStateWarning, fmt.Errorf("--verified-path-expiry cannot be used with --insecure-skip-verify")
 	}
-	if !strings.HasPrefix(line, "a001 OK Begin TLS") {
-		return fmt.Errorf("expected IMAP STARTTLS OK, got: %v", strings.TrimSpace(line))
+	if plugin.VerifiedPathPolicy && plugin.InsecureSkipVerify {
+		return sensu.CheckStateWarning, fmt.Errorf("--verified-path-policy cannot be used with --insecure-skip-verify")
 	}
-	return nil
+	return sensu.CheckStateOK, nil
 }
 
 func executeCheck(event *corev2.Event) (int, error) {
-	connectAddr := plugin.Address
-	if connectAddr == "" {
-		connectAddr = plugin.Host
+	expiryPolicy, err := expiry.NewPolicy(plugin.Warning, plugin.Critical, plugin.NotBeforeGrace)
+	if err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	dialAddr := net.JoinHostPort(connectAddr, fmt.Sprint(plugin.Port))
-
-	timeout := time.Duration(plugin.Timeout) * time.Second
-	tcpConn, err := net.DialTimeout("tcp", dialAddr, timeout)
+	cryptoPolicy, err := certpolicy.New(plugin.MinRSABits, plugin.ECDSACurves, plugin.AllowWeakSignatures, plugin.MaxValidity)
 	if err != nil {
-		return sensu.CheckStateCritical, fmt.Errorf("connection failed: %v", err)
+		return sensu.CheckStateWarning, err
 	}
 
-	switch plugin.StartTLS {
-	case "smtp":
-		if err := starttlsSMTP(tcpConn); err != nil {
-			_ = tcpConn.Close()
-			return sensu.CheckStateCritical, err
-		}
-	case "imap":
-		if err := starttlsIMAP(tcpConn); err != nil {
-			_ = tcpConn.Close()
-			return sensu.CheckStateCritical, err
+	// A nil pool makes chain verification use the system roots.
+	var roots *x509.CertPool
+	if len(plugin.TrustedCAFile) > 0 {
+		pool, err := corev2.LoadCACerts(plugin.TrustedCAFile)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("error loading specified CA file: %v", err)
 		}
+		roots = pool
 	}
 
-	tlsCfg := &tls.Config{ServerName: plugin.Host, InsecureSkipVerify: plugin.InsecureSkipVerify} //nolint:gosec
+	timeout := time.Duration(plugin.Timeout) * time.Second
+	tcpConn, extensions, err := dialServer(timeout)
+	if err != nil {
+		return sensu.CheckStateCritical, err
+	}
 
-	if plugin.ClientCert != "" && plugin.ClientKey != "" {
-		certData, err := os.ReadFile(plugin.ClientCert)
-		if err != nil {
-			_ = tcpConn.Close()
-			return sensu.CheckStateCritical, fmt.Errorf("reading client cert: %v", err)
-		}
-		keyData, err := os.ReadFile(plugin.ClientKey)
-		if err != nil {
-			_ = tcpConn.Close()
-			return sensu.CheckStateCritical, fmt.Errorf("reading client key: %v", err)
-		}
-		kp, err := tls.X509KeyPair(certData, keyData)
-		if err != nil {
-			_ = tcpConn.Close()
-			return sensu.CheckStateCritical, fmt.Errorf("loading client cert/key: %v", err)
-		}
-		tlsCfg.Certificates = []tls.Certificate{kp}
+	// The handshake never verifies the server, so that a bad chain or name is
+	// reported by the checks below instead of as a bare handshake error.
+	tlsCfg := &tls.Config{ServerName: plugin.Host, InsecureSkipVerify: true} //nolint:gosec
+	if tlsCfg.Certificates, err = clientCertificates(); err != nil {
+		_ = tcpConn.Close()
+		return sensu.CheckStateCritical, err
 	}
 
 	tlsConn := tls.Client(tcpConn, tlsCfg)
@@ -241,39 +415,233 @@ func executeCheck(event *corev2.Event) (int, error) {
 		return sensu.CheckStateCritical, fmt.Errorf("no certificates returned by server")
 	}
 
-	if !plugin.SkipHostnameVerification {
+	if !plugin.InsecureSkipVerify && !plugin.SkipHostnameVerification {
 		if err := chain[0].VerifyHostname(plugin.Host); err != nil {
 			return sensu.CheckStateCritical, fmt.Errorf("%v hostname mismatch: %v", plugin.Host, err)
 		}
 	}
 
-	if !plugin.SkipChainVerification && len(chain) > 1 {
-		for i := 0; i < len(chain)-1; i++ {
-			if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
-				return sensu.CheckStateCritical, fmt.Errorf("%v invalid certificate chain at position %d: %v", plugin.Host, i, err)
-			}
+	var verifiedPath []*x509.Certificate
+	if !plugin.InsecureSkipVerify && !plugin.SkipChainVerification {
+		verifiedPath, err = verifyChain(chain, roots)
+		if err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("%v chain verification failed: %v", plugin.Host, err)
+		}
+	}
+
+	var status int
+	var headline string
+	var lines []string
+	switch {
+	case plugin.VerifiedPathExpiry:
+		status, headline, lines = checkChainExpiry(expiryPolicy, chain, expiry.PathOnly(chain, verifiedPath), plugin.Host)
+	case plugin.ChainExpiry:
+		status, headline, lines = checkChainExpiry(expiryPolicy, chain, nil, plugin.Host)
+	default:
+		status, headline, lines = checkExpiry(expiryPolicy, chain[0], plugin.Host)
+	}
+	// Every other check adds its findings to lines, so that the headline
+	// printed last can lead with the final state.
+	add := func(findingStatus int, findings []string) {
+		if findingStatus > status {
+			status = findingStatus
+		}
+		lines = append(lines, findings...)
+	}
+	policyChecked := chain
+	if plugin.VerifiedPathPolicy {
+		policyChecked = append(append([]*x509.Certificate{}, chain...), expiry.PathOnly(chain, verifiedPath)...)
+	}
+	add(cryptoPolicy.Check(policyChecked))
+	if len(plugin.PinCert) > 0 || len(plugin.PinSPKI) > 0 {
+		pinStatus, pinLines, err := pins.Check(chain, expiry.PathOnly(chain, verifiedPath), plugin.PinCert, plugin.PinSPKI, plugin.Host)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		add(pinStatus, pinLines)
+	}
+	if len(plugin.StateFile) > 0 {
+		target := net.JoinHostPort(plugin.Host, fmt.Sprint(plugin.Port))
+		if plugin.Address != "" {
+			target += " via " + plugin.Address
+		}
+		add(certstate.Check(plugin.StateFile, plugin.ChangeState, target, chain[0]))
+	}
+	if plugin.OCSP {
+		issuer, err := issuerOf(chain, verifiedPath)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		ocspStatus, ocspLine, err := checkOCSP(chain[0], issuer)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		add(ocspStatus, []string{ocspLine})
+	}
+	if plugin.OCSPStaple || hasMustStaple(chain[0]) {
+		stapleStatus, stapleLine, err := checkStaple(chain, verifiedPath, tlsConn.ConnectionState().OCSPResponse)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		add(stapleStatus, []string{stapleLine})
+	}
+	if plugin.Protocols {
+		protocolStatus, protocolLines, err := checkProtocols(timeout)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		add(protocolStatus, protocolLines)
+	}
+	if plugin.Ciphers {
+		cipherStatus, cipherLines, err := checkCiphers(timeout)
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		add(cipherStatus, cipherLines)
+	}
+	if len(verifiedPath) > 0 {
+		lines = append(lines, fmt.Sprintf("verified path: %v", describePath(verifiedPath)))
+	}
+	if len(extensions) > 0 {
+		lines = append(lines, fmt.Sprintf("%v extensions offered before STARTTLS: %v", plugin.StartTLS, strings.Join(extensions, ", ")))
+	}
+
+	fmt.Printf("%v: %v\n", expiry.StateLabel(status), headline)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return status, nil
+}
+
+// dialServer connects to --address (or --host) and runs the --starttls
+// negotiation, returning the connection ready for the TLS handshake along with
+// the extensions the server offered before the upgrade.
+func dialServer(timeout time.Duration) (net.Conn, []string, error) {
+	connectAddr := plugin.Address
+	if connectAddr == "" {
+		connectAddr = plugin.Host
+	}
+	dialAddr := net.JoinHostPort(connectAddr, fmt.Sprint(plugin.Port))
+
+	conn, err := net.DialTimeout("tcp", dialAddr, timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connection failed: %v", err)
+	}
+	var extensions []string
+	if negotiate, ok := starttlsNegotiators[plugin.StartTLS]; ok {
+		if extensions, err = negotiate(conn); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
 		}
 	}
+	return conn, extensions, nil
+}
+
+// clientCertificates loads the --client-cert and --client-key pair for mutual
+// TLS, or returns nil when they are not both set.
+func clientCertificates() ([]tls.Certificate, error) {
+	if plugin.ClientCert == "" || plugin.ClientKey == "" {
+		return nil, nil
+	}
+	certData, err := os.ReadFile(plugin.ClientCert)
+	if err != nil {
+		return nil, fmt.Errorf("reading client cert: %v", err)
+	}
+	keyData, err := os.ReadFile(plugin.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("reading client key: %v", err)
+	}
+	kp, err := tls.X509KeyPair(certData, keyData)
+	if err != nil {
+		return nil, fmt.Errorf("loading client cert/key: %v", err)
+	}
+	return []tls.Certificate{kp}, nil
+}
 
-	return checkExpiry(chain[0], plugin.Host)
+// verifyChain builds a path from the served leaf through the served
+// intermediates to a trusted root and verifies it for server authentication.
+// Hostname verification is handled separately so it can be skipped on its own.
+func verifyChain(chain []*x509.Certificate, roots *x509.CertPool) ([]*x509.Certificate, error) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	paths, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return nil, classifyVerifyError(err, chain)
+	}
+	return paths[0], nil
 }
 
-func checkExpiry(cert *x509.Certificate, source string) (int, error) {
-	timeNow := time.Now()
-	expiresInDays := int(cert.NotAfter.Sub(timeNow).Hours() / 24)
+// classifyVerifyError names the failure class of a path verification error and
+// the certificate it concerns.
+func classifyVerifyError(err error, chain []*x509.Certificate) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		return fmt.Errorf("unknown authority: %v", err)
+	}
+	var invalid x509.CertificateInvalidError
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	class := "invalid certificate"
+	switch invalid.Reason {
+	case x509.Expired:
+		class = "expired or not yet valid certificate"
+		if chainPosition(invalid.Cert, chain) > 0 {
+			class = "expired or not yet valid intermediate"
+		}
+	case x509.CANotAuthorizedForThisName, x509.NameConstraintsWithoutSANs, x509.UnconstrainedName, x509.TooManyConstraints:
+		class = "name constraints violation"
+	case x509.IncompatibleUsage, x509.CANotAuthorizedForExtKeyUsage:
+		class = "bad extended key usage"
+	case x509.NotAuthorizedToSign:
+		class = "issuer not authorized to sign certificates"
+	case x509.TooManyIntermediates:
+		class = "path length constraint violation"
+	}
 
-	if expiresInDays < 0 {
-		fmt.Printf("critical: %v cert expired %v days ago\n", source, -expiresInDays)
-		return sensu.CheckStateCritical, nil
+	position := "from trust store"
+	if i := chainPosition(invalid.Cert, chain); i >= 0 {
+		position = fmt.Sprintf("at position %d", i)
 	}
-	if timeNow.AddDate(0, 0, plugin.Critical).After(cert.NotAfter) {
-		fmt.Printf("critical: %v cert expires in %v days\n", source, expiresInDays)
-		return sensu.CheckStateCritical, nil
+	return fmt.Errorf("%v: %q %v: %v", class, invalid.Cert.Subject.String(), position, err)
+}
+
+// chainPosition returns the index of cert in the served chain, or -1.
+func chainPosition(cert *x509.Certificate, chain []*x509.Certificate) int {
+	for i, c := range chain {
+		if cert != nil && c.Equal(cert) {
+			return i
+		}
 	}
-	if timeNow.AddDate(0, 0, plugin.Warning).After(cert.NotAfter) {
-		fmt.Printf("warning: %v cert expires in %v days\n", source, expiresInDays)
-		return sensu.CheckStateWarning, nil
+	return -1
+}
+
+// describePath renders a certificate path as "subject -> subject -> ...".
+func describePath(path []*x509.Certificate) string {
+	subjects := make([]string, len(path))
+	for i, cert := range path {
+		subjects[i] = fmt.Sprintf("%q", cert.Subject.String())
 	}
-	fmt.Printf("ok: %v cert expires in %v days\n", source, expiresInDays)
-	return sensu.CheckStateOK, nil
+	return strings.Join(subjects, " -> ")
+}
+
+func checkExpiry(expiryPolicy expiry.Policy, cert *x509.Certificate, source string) (int, string, []string) {
+	timeNow := time.Now()
+	state, _ := expiryPolicy.State(cert, timeNow)
+	return state, fmt.Sprintf("%v cert %v", source, expiry.DescribeValidity(cert, timeNow)), nil
+}
+
+// checkChainExpiry applies the expiry thresholds to every certificate the
+// server presented, plus any certificates only found in the verified path,
+// and reports the one closest to expiry. The state is the worst in the chain.
+func checkChainExpiry(expiryPolicy expiry.Policy, chain, pathOnly []*x509.Certificate, source string) (int, string, []string) {
+	report := expiryPolicy.CheckChain(chain, pathOnly, time.Now())
+	return report.State, fmt.Sprintf("%v chain of %v", source, report.Summary), report.Lines
 }
@@ -1,20 +1,25 @@
 package main
 
 import (
-	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"fmt"
+	"encoding/pem"
+	"io"
 	"math/big"
 	"net"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
 )
 
 // TestCheckArgs validates flag validation logic.
@@ -28,50 +33,168 @@ func TestCheckArgs(t *testing.T) {
 	}{
 		{
 			name:        "missing host",
-			config:      Config{Warning: 14, Critical: 7},
+			config:      Config{Warning: "14", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--host is required",
 		},
 		{
 			name:        "warning not greater than critical",
-			config:      Config{Host: "example.com", Warning: 7, Critical: 7},
+			config:      Config{Host: "example.com", Warning: "7", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--warning must be greater than --critical",
 		},
 		{
 			name:        "warning less than critical",
-			config:      Config{Host: "example.com", Warning: 5, Critical: 7},
+			config:      Config{Host: "example.com", Warning: "5", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--warning must be greater than --critical",
 		},
+		{
+			name:        "invalid threshold",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "-5%"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--critical: percentage",
+		},
 		{
 			name:        "invalid starttls protocol",
-			config:      Config{Host: "example.com", Warning: 14, Critical: 7, StartTLS: "ftp"},
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", StartTLS: "gopher"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--starttls must be one of: ftp, imap, ldap, mysql, pop3, postgres, smtp, xmpp",
+		},
+		{
+			name:        "verified path expiry without chain verification",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", VerifiedPathExpiry: true, SkipChainVerification: true},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--verified-path-expiry cannot be used with --skip-chain-verification",
+		},
+		{
+			name:        "verified path policy without chain verification",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", VerifiedPathPolicy: true, SkipChainVerification: true},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
-			errContains: "--starttls must be 'smtp' or 'imap'",
+			errContains: "--verified-path-policy cannot be used with --skip-chain-verification",
+		},
+		{
+			name:        "verified path expiry with insecure skip verify",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", VerifiedPathExpiry: true, InsecureSkipVerify: true},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--verified-path-expiry cannot be used with --insecure-skip-verify",
+		},
+		{
+			name:        "verified path policy with insecure skip verify",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", VerifiedPathPolicy: true, InsecureSkipVerify: true},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--verified-path-policy cannot be used with --insecure-skip-verify",
 		},
 		{
 			name:       "valid config",
-			config:     Config{Host: "example.com", Warning: 14, Critical: 7},
+			config:     Config{Host: "example.com", Warning: "14", Critical: "7"},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
 		},
+		{
+			name:       "valid percentage thresholds",
+			config:     Config{Host: "example.com", Warning: "30%", Critical: "10%"},
+			wantStatus: sensu.CheckStateOK,
+		},
 		{
 			name:       "valid with smtp starttls",
-			config:     Config{Host: "example.com", Warning: 14, Critical: 7, StartTLS: "smtp"},
+			config:     Config{Host: "example.com", Warning: "14", Critical: "7", StartTLS: "smtp"},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
 		},
 		{
 			name:       "valid with imap starttls",
-			config:     Config{Host: "example.com", Warning: 14, Critical: 7, StartTLS: "imap"},
+			config:     Config{Host: "example.com", Warning: "14", Critical: "7", StartTLS: "imap"},
+			wantStatus: sensu.CheckStateOK,
+			wantErr:    false,
+		},
+		{
+			name:       "valid with postgres starttls",
+			config:     Config{Host: "example.com", Warning: "14", Critical: "7", StartTLS: "postgres"},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
 		},
+		{
+			name:        "invalid not-before grace",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", NotBeforeGrace: "-5m"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--not-before-grace must be a duration",
+		},
+		{
+			name:       "valid not-before grace",
+			config:     Config{Host: "example.com", Warning: "14", Critical: "7", NotBeforeGrace: "5m"},
+			wantStatus: sensu.CheckStateOK,
+			wantErr:    false,
+		},
+		{
+			name:        "unknown denied protocol",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", ProtocolsDeny: []string{"1.0", "SSLv3"}},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--protocols-deny: unknown TLS version",
+		},
+		{
+			name:        "protocol both denied and required",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", ProtocolsDeny: []string{"1.2"}, ProtocolsRequire: []string{"TLS1.2"}},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "cannot be in both --protocols-deny and --protocols-require",
+		},
+		{
+			name:        "unknown cipher suite",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", CiphersAllow: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "AES128-SHA"}},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--ciphers-allow: unknown cipher suite",
+		},
+		{
+			name:       "cipher suite classes",
+			config:     Config{Host: "example.com", Warning: "14", Critical: "7", CiphersDeny: []string{"insecure", "CBC", "non-pfs"}},
+			wantStatus: sensu.CheckStateOK,
+		},
+		{
+			name:        "unknown ecdsa curve",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", ECDSACurves: []string{"P-256", "secp256k1"}},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--ecdsa-curves: unknown curve",
+		},
+		{
+			name:        "invalid max validity",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", MaxValidity: "13 months"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--max-validity must be days or a duration",
+		},
+		{
+			name:       "max validity in days",
+			config:     Config{Host: "example.com", Warning: "14", Critical: "7", MinRSABits: 3072, MaxValidity: "398"},
+			wantStatus: sensu.CheckStateOK,
+		},
+		{
+			name:        "invalid spki pin",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", PinSPKI: []string{"sha256/not-base64"}},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--pin-spki: ",
+		},
+		{
+			name:        "invalid change state",
+			config:      Config{Host: "example.com", Warning: "14", Critical: "7", StateFile: "/tmp/state.json", ChangeState: "info"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--change-state must be one of",
+		},
 	}
 
 	for _, tt := range tests {
@@ -96,30 +219,32 @@ func TestCheckArgs(t *testing.T) {
 
 // TestCheckExpiry tests the expiry checking function.
 func TestCheckExpiry(t *testing.T) {
+	const day = 24 * time.Hour
 	tests := []struct {
 		name       string
-		days       int
-		warning    int
-		critical   int
+		expiresIn  time.Duration
+		warning    string
+		critical   string
 		wantStatus int
 	}{
-		{"already expired", -1, 14, 7, sensu.CheckStateCritical},
-		{"within critical", 3, 14, 7, sensu.CheckStateCritical},
-		{"within critical boundary", 6, 14, 7, sensu.CheckStateCritical},
-		{"within warning", 10, 14, 7, sensu.CheckStateWarning},
-		{"within warning boundary", 13, 14, 7, sensu.CheckStateWarning},
-		{"ok", 30, 14, 7, sensu.CheckStateOK},
+		{"already expired", -1 * day, "14", "7", sensu.CheckStateCritical},
+		{"within critical", 3 * day, "14", "7", sensu.CheckStateCritical},
+		{"within critical boundary", 6 * day, "14", "7", sensu.CheckStateCritical},
+		{"within warning", 10 * day, "14", "7", sensu.CheckStateWarning},
+		{"within warning boundary", 13 * day, "14", "7", sensu.CheckStateWarning},
+		{"ok", 30 * day, "14", "7", sensu.CheckStateOK},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			plugin = Config{Warning: tt.warning, Critical: tt.critical}
-			cert := &x509.Certificate{
-				NotAfter: time.Now().Add(time.Duration(tt.days) * 24 * time.Hour),
-			}
-			status, err := checkExpiry(cert, "test")
+			expiryPolicy, err := expiry.NewPolicy(tt.warning, tt.critical, "")
 			if err != nil {
-				t.Fatalf("checkExpiry() unexpected error: %v", err)
+				t.Fatal(err)
+			}
+			cert := &x509.Certificate{NotAfter: time.Now().Add(tt.expiresIn)}
+			status, headline, _ := checkExpiry(expiryPolicy, cert, "test")
+			if !strings.Contains(headline, "test") {
+				t.Errorf("checkExpiry() headline = %q, want it to name the source", headline)
 			}
 			if status != tt.wantStatus {
 				t.Errorf("checkExpiry() status = %v, want %v", status, tt.wantStatus)
@@ -128,156 +253,78 @@ func TestCheckExpiry(t *testing.T) {
 	}
 }
 
-// TestStartTLSSMTP tests the SMTP STARTTLS handshake function.
-func TestStartTLSSMTP(t *testing.T) {
-	t.Run("successful handshake", func(t *testing.T) {
-		server, client := net.Pipe()
-		defer func() { _ = server.Close(); _ = client.Close() }()
-
-		go func() {
-			// SMTP server side: send banner, read STARTTLS, send 220
-			_, _ = fmt.Fprintf(server, "220 mail.example.com ESMTP ready\r\n")
-			r := bufio.NewReader(server)
-			line, _ := r.ReadString('\n')
-			if strings.TrimSpace(line) == "STARTTLS" {
-				_, _ = fmt.Fprintf(server, "220 Go ahead\r\n")
-			}
-		}()
-
-		if err := starttlsSMTP(client); err != nil {
-			t.Errorf("starttlsSMTP() unexpected error: %v", err)
-		}
-	})
-
-	t.Run("bad initial banner", func(t *testing.T) {
-		server, client := net.Pipe()
-		defer func() { _ = server.Close(); _ = client.Close() }()
-
-		go func() {
-			_, _ = fmt.Fprintf(server, "421 Service not available\r\n")
-		}()
-
-		if err := starttlsSMTP(client); err == nil {
-			t.Error("starttlsSMTP() expected error for non-220 banner")
-		}
-	})
-
-	t.Run("bad STARTTLS response", func(t *testing.T) {
-		server, client := net.Pipe()
-		defer func() { _ = server.Close(); _ = client.Close() }()
-
-		go func() {
-			_, _ = fmt.Fprintf(server, "220 ready\r\n")
-			r := bufio.NewReader(server)
-			_, _ = r.ReadString('\n')
-			_, _ = fmt.Fprintf(server, "454 TLS not available\r\n")
-		}()
-
-		if err := starttlsSMTP(client); err == nil {
-			t.Error("starttlsSMTP() expected error for non-220 STARTTLS response")
-		}
-	})
-}
-
-// TestStartTLSIMAP tests the IMAP STARTTLS handshake function.
-func TestStartTLSIMAP(t *testing.T) {
-	t.Run("successful handshake", func(t *testing.T) {
-		server, client := net.Pipe()
-		defer func() { _ = server.Close(); _ = client.Close() }()
-
-		go func() {
-			_, _ = fmt.Fprintf(server, "* OK Dovecot ready\r\n")
-			r := bufio.NewReader(server)
-			_, _ = r.ReadString('\n')
-			_, _ = fmt.Fprintf(server, "a001 OK Begin TLS negotiation now\r\n")
-		}()
-
-		if err := starttlsIMAP(client); err != nil {
-			t.Errorf("starttlsIMAP() unexpected error: %v", err)
-		}
-	})
-
-	t.Run("bad initial banner", func(t *testing.T) {
-		server, client := net.Pipe()
-		defer func() { _ = server.Close(); _ = client.Close() }()
-
-		go func() {
-			_, _ = fmt.Fprintf(server, "* BYE Server shutting down\r\n")
-		}()
-
-		if err := starttlsIMAP(client); err == nil {
-			t.Error("starttlsIMAP() expected error for non-OK banner")
-		}
-	})
-
-	t.Run("bad STARTTLS response", func(t *testing.T) {
-		server, client := net.Pipe()
-		defer func() { _ = server.Close(); _ = client.Close() }()
-
-		go func() {
-			_, _ = fmt.Fprintf(server, "* OK ready\r\n")
-			r := bufio.NewReader(server)
-			_, _ = r.ReadString('\n')
-			_, _ = fmt.Fprintf(server, "a001 NO TLS not supported\r\n")
-		}()
-
-		if err := starttlsIMAP(client); err == nil {
-			t.Error("starttlsIMAP() expected error for NO response")
-		}
-	})
-}
-
 // TestExecuteCheck tests end-to-end certificate checking against a local TLS server.
 func TestExecuteCheck(t *testing.T) {
 	tests := []struct {
 		name       string
 		config     Config
-		setupFunc  func(t *testing.T) (string, int, func())
+		setupFunc  func(t *testing.T) (string, int, string, func())
 		wantStatus int
 		wantErr    bool
 	}{
 		{
 			name:   "ok cert",
-			config: Config{Warning: 14, Critical: 7, InsecureSkipVerify: true},
-			setupFunc: func(t *testing.T) (string, int, func()) {
+			config: Config{Warning: "14", Critical: "7"},
+			setupFunc: func(t *testing.T) (string, int, string, func()) {
 				return startTLSServer(t, 365)
 			},
 			wantStatus: sensu.CheckStateOK,
 		},
 		{
 			name:   "critical expiry",
-			config: Config{Warning: 14, Critical: 7, InsecureSkipVerify: true},
-			setupFunc: func(t *testing.T) (string, int, func()) {
+			config: Config{Warning: "14", Critical: "7"},
+			setupFunc: func(t *testing.T) (string, int, string, func()) {
 				return startTLSServer(t, 3)
 			},
 			wantStatus: sensu.CheckStateCritical,
 		},
 		{
 			name:   "warning expiry",
-			config: Config{Warning: 14, Critical: 7, InsecureSkipVerify: true},
-			setupFunc: func(t *testing.T) (string, int, func()) {
+			config: Config{Warning: "14", Critical: "7"},
+			setupFunc: func(t *testing.T) (string, int, string, func()) {
 				return startTLSServer(t, 10)
 			},
 			wantStatus: sensu.CheckStateWarning,
 		},
 		{
 			name:       "connection failure",
-			config:     Config{Host: "127.0.0.1", Port: 1, Warning: 14, Critical: 7},
+			config:     Config{Host: "127.0.0.1", Port: 1, Warning: "14", Critical: "7"},
 			wantStatus: sensu.CheckStateCritical,
 			wantErr:    true,
 		},
 		{
 			name:   "skip hostname verification",
-			config: Config{Warning: 14, Critical: 7, InsecureSkipVerify: true, SkipHostnameVerification: true},
-			setupFunc: func(t *testing.T) (string, int, func()) {
+			config: Config{Warning: "14", Critical: "7", SkipHostnameVerification: true},
+			setupFunc: func(t *testing.T) (string, int, string, func()) {
 				return startTLSServer(t, 365)
 			},
 			wantStatus: sensu.CheckStateOK,
 		},
+		{
+			name:   "unknown authority",
+			config: Config{Warning: "14", Critical: "7"},
+			setupFunc: func(t *testing.T) (string, int, string, func()) {
+				host, port, _, cleanup := startTLSServer(t, 365)
+				otherDER, _ := generateCert(t, 365)
+				return host, port, writeCertPEM(t, otherDER), cleanup
+			},
+			wantStatus: sensu.CheckStateCritical,
+			wantErr:    true,
+		},
+		{
+			name:   "unknown authority with insecure skip verify",
+			config: Config{Warning: "14", Critical: "7", InsecureSkipVerify: true},
+			setupFunc: func(t *testing.T) (string, int, string, func()) {
+				host, port, _, cleanup := startTLSServer(t, 365)
+				otherDER, _ := generateCert(t, 365)
+				return host, port, writeCertPEM(t, otherDER), cleanup
+			},
+			wantStatus: sensu.CheckStateOK,
+		},
 		{
 			name:   "skip chain verification",
-			config: Config{Warning: 14, Critical: 7, InsecureSkipVerify: true, SkipChainVerification: true},
-			setupFunc: func(t *testing.T) (string, int, func()) {
+			config: Config{Warning: "14", Critical: "7", SkipChainVerification: true},
+			setupFunc: func(t *testing.T) (string, int, string, func()) {
 				return startTLSServer(t, 365)
 			},
 			wantStatus: sensu.CheckStateOK,
@@ -288,9 +335,10 @@ func TestExecuteCheck(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			var cleanup func()
 			if tt.setupFunc != nil {
-				host, port, cleanupFn := tt.setupFunc(t)
+				host, port, caFile, cleanupFn := tt.setupFunc(t)
 				tt.config.Host = host
 				tt.config.Port = port
+				tt.config.TrustedCAFile = caFile
 				cleanup = cleanupFn
 				defer cleanup()
 			}
@@ -308,6 +356,158 @@ func TestExecuteCheck(t *testing.T) {
 	}
 }
 
+// TestVerifyChain tests path building against a trust store and the failure classes it reports.
+func TestVerifyChain(t *testing.T) {
+	now := time.Now()
+	yearAgo, nextYear := now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)
+
+	root, rootKey := issueCert(t, caTemplate(1, "Test Root", yearAgo, nextYear), nil, nil)
+	intermediate, intKey := issueCert(t, caTemplate(2, "Test Intermediate", yearAgo, nextYear), root, rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	t.Run("valid path", func(t *testing.T) {
+		leaf, _ := issueCert(t, leafTemplate(3, "www.example.com", nextYear), intermediate, intKey)
+		path, err := verifyChain([]*x509.Certificate{leaf, intermediate}, roots)
+		if err != nil {
+			t.Fatalf("verifyChain() unexpected error: %v", err)
+		}
+		if len(path) != 3 || !path[2].Equal(root) {
+			t.Errorf("verifyChain() path = %v, want leaf -> intermediate -> root", describePath(path))
+		}
+	})
+
+	tests := []struct {
+		name        string
+		chain       func(t *testing.T) []*x509.Certificate
+		errContains string
+	}{
+		{
+			name: "unknown authority",
+			chain: func(t *testing.T) []*x509.Certificate {
+				otherRoot, otherKey := issueCert(t, caTemplate(10, "Other Root", yearAgo, nextYear), nil, nil)
+				leaf, _ := issueCert(t, leafTemplate(11, "www.example.com", nextYear), otherRoot, otherKey)
+				return []*x509.Certificate{leaf, otherRoot}
+			},
+			errContains: "unknown authority",
+		},
+		{
+			name: "missing intermediate",
+			chain: func(t *testing.T) []*x509.Certificate {
+				leaf, _ := issueCert(t, leafTemplate(12, "www.example.com", nextYear), intermediate, intKey)
+				return []*x509.Certificate{leaf}
+			},
+			errContains: "unknown authority",
+		},
+		{
+			name: "expired intermediate",
+			chain: func(t *testing.T) []*x509.Certificate {
+				expired, expiredKey := issueCert(t, caTemplate(13, "Expired Intermediate", yearAgo, now.Add(-time.Hour)), root, rootKey)
+				leaf, _ := issueCert(t, leafTemplate(14, "www.example.com", nextYear), expired, expiredKey)
+				return []*x509.Certificate{leaf, expired}
+			},
+			errContains: `expired or not yet valid intermediate: "CN=Expired Intermediate" at position 1`,
+		},
+		{
+			name: "name constraints violation",
+			chain: func(t *testing.T) []*x509.Certificate {
+				tmpl := caTemplate(15, "Constrained Intermediate", yearAgo, nextYear)
+				tmpl.PermittedDNSDomains = []string{"example.org"}
+				constrained, constrainedKey := issueCert(t, tmpl, root, rootKey)
+				leaf, _ := issueCert(t, leafTemplate(16, "www.example.com", nextYear), constrained, constrainedKey)
+				return []*x509.Certificate{leaf, constrained}
+			},
+			errContains: "name constraints violation",
+		},
+		{
+			name: "bad extended key usage",
+			chain: func(t *testing.T) []*x509.Certificate {
+				tmpl := leafTemplate(17, "www.example.com", nextYear)
+				tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+				leaf, _ := issueCert(t, tmpl, intermediate, intKey)
+				return []*x509.Certificate{leaf, intermediate}
+			},
+			errContains: "bad extended key usage",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyChain(tt.chain(t), roots)
+			if err == nil {
+				t.Fatal("verifyChain() expected error")
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("verifyChain() error = %q, want it to contain %q", err.Error(), tt.errContains)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckChain runs the full check against a server presenting leaf and intermediate.
+func TestExecuteCheckChain(t *testing.T) {
+	now := time.Now()
+	root, rootKey := issueCert(t, caTemplate(1, "Test Root", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), nil, nil)
+
+	tests := []struct {
+		name            string
+		intermediateEnd time.Time
+		chainExpiry     bool
+		insecure        bool
+		wantStatus      int
+		wantErr         string
+	}{
+		{"valid chain", now.AddDate(1, 0, 0), false, false, sensu.CheckStateOK, ""},
+		{"expired intermediate", now.Add(-time.Hour), false, false, sensu.CheckStateCritical, "expired or not yet valid intermediate"},
+		{"expired intermediate with insecure skip verify", now.Add(-time.Hour), false, true, sensu.CheckStateOK, ""},
+		{"intermediate near expiry ignored by default", now.AddDate(0, 0, 3), false, false, sensu.CheckStateOK, ""},
+		{"intermediate near expiry with chain expiry", now.AddDate(0, 0, 3), true, false, sensu.CheckStateCritical, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intermediate, intKey := issueCert(t, caTemplate(2, "Test Intermediate", now.AddDate(-1, 0, 0), tt.intermediateEnd), root, rootKey)
+			tmpl := leafTemplate(3, "localhost", now.AddDate(0, 6, 0))
+			tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+			leaf, leafKey := issueCert(t, tmpl, intermediate, intKey)
+
+			tlsCert := tls.Certificate{Certificate: [][]byte{leaf.Raw, intermediate.Raw}, PrivateKey: leafKey}
+			l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCert}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = l.Close() }()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				_ = conn.(*tls.Conn).Handshake()
+				time.Sleep(50 * time.Millisecond)
+				_ = conn.Close()
+			}()
+
+			plugin = Config{
+				Host:               "127.0.0.1",
+				Port:               l.Addr().(*net.TCPAddr).Port,
+				Warning:            "14",
+				Critical:           "7",
+				InsecureSkipVerify: tt.insecure,
+				TrustedCAFile:      writeCertPEM(t, root.Raw),
+				ChainExpiry:        tt.chainExpiry,
+				Timeout:            5,
+			}
+			status, err := executeCheck(nil)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("executeCheck() error = %v, want %q", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
 // --- helpers ---
 
 func generateCert(t *testing.T, days int) (certDER []byte, priv *rsa.PrivateKey) {
@@ -333,7 +533,8 @@ func generateCert(t *testing.T, days int) (certDER []byte, priv *rsa.PrivateKey)
 	return certDER, priv
 }
 
-func startTLSServer(t *testing.T, days int) (host string, port int, cleanup func()) {
+// startTLSServer serves a self-signed certificate and returns a CA file trusting it.
+func startTLSServer(t *testing.T, days int) (host string, port int, caFile string, cleanup func()) {
 	t.Helper()
 	certDER, priv := generateCert(t, days)
 	tlsCert := tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: priv}
@@ -357,6 +558,87 @@ func startTLSServer(t *testing.T, days int) (host string, port int, cleanup func
 		}
 	}()
 	addr := l.Addr().(*net.TCPAddr)
-	return "127.0.0.1", addr.Port, func() { _ = l.Close() }
+	return "127.0.0.1", addr.Port, writeCertPEM(t, certDER), func() { _ = l.Close() }
 }
 
+func writeCertPEM(t *testing.T, certDERs ...[]byte) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "ca-*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	for _, der := range certDERs {
+		if err := pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f.Name()
+}
+
+// issueCert signs template with parentKey, or self-signs it when parent is nil.
+func issueCert(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// caTemplate returns a CA certificate template valid from notBefore to notAfter.
+func caTemplate(serial int64, cn string, notBefore, notAfter time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+// leafTemplate returns a server certificate template for dnsName.
+func leafTemplate(serial int64, dnsName string, notAfter time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{dnsName},
+	}
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	_ = w.Close()
+	return string(<-done)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"golang.org/x/crypto/ocsp"
+)
+
+// revocationReasons names the RFC 5280 CRLReason codes.
+var revocationReasons = map[int]string{
+	ocsp.Unspecified:          "unspecified",
+	ocsp.KeyCompromise:        "keyCompromise",
+	ocsp.CACompromise:         "cACompromise",
+	ocsp.AffiliationChanged:   "affiliationChanged",
+	ocsp.Superseded:           "superseded",
+	ocsp.CessationOfOperation: "cessationOfOperation",
+	ocsp.CertificateHold:      "certificateHold",
+	ocsp.RemoveFromCRL:        "removeFromCRL",
+	ocsp.PrivilegeWithdrawn:   "privilegeWithdrawn",
+	ocsp.AACompromise:         "aACompromise",
+}
+
+// oidTLSFeature identifies the TLS Feature extension (RFC 7633).
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// tlsFeatureStatusRequest is the status_request feature, better known as
+// Must-Staple.
+const tlsFeatureStatusRequest = 5
+
+// hasMustStaple reports whether cert requires a stapled OCSP response.
+func hasMustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, feature := range features {
+			if feature == tlsFeatureStatusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// issuerOf returns the certificate that issued the leaf, preferring the
+// verified path over the certificates presented by the server.
+func issuerOf(chain, verifiedPath []*x509.Certificate) (*x509.Certificate, error) {
+	if len(verifiedPath) > 1 {
+		return verifiedPath[1], nil
+	}
+	if len(chain) > 1 {
+		return chain[1], nil
+	}
+	return nil, fmt.Errorf("issuer certificate not available: server sent no intermediate and the chain was not verified")
+}
+
+// checkOCSP asks the leaf's OCSP responder, or --ocsp-url, for its revocation
+// status and evaluates the signed response.
+func checkOCSP(leaf, issuer *x509.Certificate) (int, string, error) {
+	responder := plugin.OCSPURL
+	if responder == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return sensu.CheckStateCritical, "", fmt.Errorf("certificate has no OCSP responder URL, use --ocsp-url")
+		}
+		responder = leaf.OCSPServer[0]
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return sensu.CheckStateCritical, "", fmt.Errorf("creating OCSP request: %v", err)
+	}
+	client := &http.Client{Timeout: time.Duration(plugin.Timeout) * time.Second}
+	resp, err := client.Post(responder, "application/ocsp-request", bytes.NewReader(req)) //nolint:gosec
+	if err != nil {
+		return sensu.CheckStateCritical, "", fmt.Errorf("querying OCSP responder %v: %v", responder, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return sensu.CheckStateCritical, "", fmt.Errorf("unexpected HTTP status %v from OCSP responder %v", resp.Status, responder)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return sensu.CheckStateCritical, "", fmt.Errorf("reading OCSP response from %v: %v", responder, err)
+	}
+
+	// ParseResponseForCert verifies the signature against the issuer, or a
+	// delegated responder certificate signed by it, and matches the serial.
+	ocspResp, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return sensu.CheckStateCritical, "", fmt.Errorf("invalid OCSP response from %v: %v", responder, err)
+	}
+	state, line := ocspResponseState(ocspResp, responder)
+	return state, line, nil
+}
+
+// ocspResponseState reports the certificate status in resp and applies the
+// OCSP warning and critical thresholds to its NextUpdate.
+func ocspResponseState(resp *ocsp.Response, source string) (int, string) {
+	switch resp.Status {
+	case ocsp.Revoked:
+		return sensu.CheckStateCritical, fmt.Sprintf("critical: OCSP %v reports certificate revoked at %v (reason: %v)", source, resp.RevokedAt, revocationReasons[resp.RevocationReason])
+	case ocsp.Unknown:
+		return sensu.CheckStateWarning, fmt.Sprintf("warning: OCSP %v reports certificate status unknown", source)
+	}
+
+	if resp.NextUpdate.IsZero() {
+		return sensu.CheckStateOK, fmt.Sprintf("ok: OCSP %v reports certificate good, response has no next update", source)
+	}
+	minutesUntil := int(time.Until(resp.NextUpdate).Minutes())
+	if minutesUntil < 0 {
+		return sensu.CheckStateCritical, fmt.Sprintf("critical: OCSP %v response is stale, next update was %v minutes ago", source, -minutesUntil)
+	}
+	if minutesUntil < plugin.OCSPCritical {
+		return sensu.CheckStateCritical, fmt.Sprintf("critical: OCSP %v reports certificate good, %v minutes until next update at %v", source, minutesUntil, resp.NextUpdate)
+	}
+	if minutesUntil < plugin.OCSPWarning {
+		return sensu.CheckStateWarning, fmt.Sprintf("warning: OCSP %v reports certificate good, %v minutes until next update at %v", source, minutesUntil, resp.NextUpdate)
+	}
+	return sensu.CheckStateOK, fmt.Sprintf("ok: OCSP %v reports certificate good, %v minutes until next update at %v", source, minutesUntil, resp.NextUpdate)
+}
+
+// checkStaple evaluates the OCSP response the server stapled to the handshake.
+// A missing staple is only a problem when the leaf carries Must-Staple.
+func checkStaple(chain, verifiedPath []*x509.Certificate, staple []byte) (int, string, error) {
+	leaf := chain[0]
+	if len(staple) == 0 {
+		if hasMustStaple(leaf) {
+			return sensu.CheckStateCritical, fmt.Sprintf("critical: %v sent no OCSP staple but the certificate requires one (Must-Staple)", plugin.Host), nil
+		}
+		return sensu.CheckStateOK, fmt.Sprintf("ok: %v sent no OCSP staple", plugin.Host), nil
+	}
+
+	issuer, err := issuerOf(chain, verifiedPath)
+	if err != nil {
+		return sensu.CheckStateCritical, "", err
+	}
+	resp, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		return sensu.CheckStateCritical, "", fmt.Errorf("invalid OCSP staple from %v: %v", plugin.Host, err)
+	}
+	state, line := ocspResponseState(resp, "staple from "+plugin.Host)
+	return state, line, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+	"golang.org/x/crypto/ocsp"
+)
+
+// TestCheckOCSP tests revocation checking against an httptest OCSP responder.
+func TestCheckOCSP(t *testing.T) {
+	now := time.Now()
+	ca, caKey := issueCert(t, caTemplate(1, "Test CA", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), nil, nil)
+	otherCA, otherKey := issueCert(t, caTemplate(2, "Other CA", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), nil, nil)
+
+	tests := []struct {
+		name        string
+		status      int
+		nextUpdate  time.Duration
+		signer      *x509.Certificate
+		signerKey   crypto.Signer
+		httpStatus  int
+		noAIA       bool
+		overrideURL bool
+		wantStatus  int
+		wantErr     bool
+	}{
+		{name: "good", status: ocsp.Good, nextUpdate: 48 * time.Hour, wantStatus: sensu.CheckStateOK},
+		{name: "good within warning", status: ocsp.Good, nextUpdate: 10 * time.Hour, wantStatus: sensu.CheckStateWarning},
+		{name: "good within critical", status: ocsp.Good, nextUpdate: time.Hour, wantStatus: sensu.CheckStateCritical},
+		{name: "stale response", status: ocsp.Good, nextUpdate: -time.Hour, wantStatus: sensu.CheckStateCritical},
+		{name: "revoked", status: ocsp.Revoked, nextUpdate: 48 * time.Hour, wantStatus: sensu.CheckStateCritical},
+		{name: "unknown", status: ocsp.Unknown, nextUpdate: 48 * time.Hour, wantStatus: sensu.CheckStateWarning},
+		{name: "signed by wrong CA", status: ocsp.Good, nextUpdate: 48 * time.Hour, signer: otherCA, signerKey: otherKey, wantStatus: sensu.CheckStateCritical, wantErr: true},
+		{name: "responder error", httpStatus: http.StatusInternalServerError, wantStatus: sensu.CheckStateCritical, wantErr: true},
+		{name: "no responder URL", noAIA: true, wantStatus: sensu.CheckStateCritical, wantErr: true},
+		{name: "override URL", status: ocsp.Good, nextUpdate: 48 * time.Hour, noAIA: true, overrideURL: true, wantStatus: sensu.CheckStateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, signerKey := tt.signer, tt.signerKey
+			if signer == nil {
+				signer, signerKey = ca, caKey
+			}
+			srv := startOCSPResponder(t, signer, signerKey, tt.httpStatus, func(*ocsp.Request) (int, time.Time) {
+				return tt.status, now.Add(tt.nextUpdate)
+			})
+			defer srv.Close()
+
+			tmpl := leafTemplate(10, "www.example.com", now.AddDate(0, 3, 0))
+			if !tt.noAIA {
+				tmpl.OCSPServer = []string{srv.URL}
+			}
+			leaf, _ := issueCert(t, tmpl, ca, caKey)
+
+			plugin = Config{Timeout: 5, OCSPWarning: 1440, OCSPCritical: 360}
+			if tt.overrideURL {
+				plugin.OCSPURL = srv.URL
+			}
+			status, _, err := checkOCSP(leaf, ca)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkOCSP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("checkOCSP() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestIssuerOf tests issuer selection from the verified path and served chain.
+func TestIssuerOf(t *testing.T) {
+	leaf, served, trusted := &x509.Certificate{}, &x509.Certificate{}, &x509.Certificate{}
+
+	if got, _ := issuerOf([]*x509.Certificate{leaf, served}, []*x509.Certificate{leaf, trusted}); got != trusted {
+		t.Error("issuerOf() should prefer the verified path")
+	}
+	if got, _ := issuerOf([]*x509.Certificate{leaf, served}, nil); got != served {
+		t.Error("issuerOf() should fall back to the served chain")
+	}
+	if _, err := issuerOf([]*x509.Certificate{leaf}, nil); err == nil {
+		t.Error("issuerOf() expected error without an issuer")
+	}
+}
+
+// TestExecuteCheckOCSP runs the full check with --ocsp against a revoked leaf.
+func TestExecuteCheckOCSP(t *testing.T) {
+	now := time.Now()
+	root, rootKey := issueCert(t, caTemplate(1, "Test Root", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), nil, nil)
+	intermediate, intKey := issueCert(t, caTemplate(2, "Test Intermediate", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), root, rootKey)
+
+	srv := startOCSPResponder(t, intermediate, intKey, 0, func(*ocsp.Request) (int, time.Time) {
+		return ocsp.Revoked, now.Add(48 * time.Hour)
+	})
+	defer srv.Close()
+
+	tmpl := leafTemplate(3, "localhost", now.AddDate(0, 6, 0))
+	tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	tmpl.OCSPServer = []string{srv.URL}
+	leaf, leafKey := issueCert(t, tmpl, intermediate, intKey)
+
+	tlsCert := tls.Certificate{Certificate: [][]byte{leaf.Raw, intermediate.Raw}, PrivateKey: leafKey}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		time.Sleep(50 * time.Millisecond)
+		_ = conn.Close()
+	}()
+
+	plugin = Config{
+		Host:          "127.0.0.1",
+		Port:          l.Addr().(*net.TCPAddr).Port,
+		Warning:       "14",
+		Critical:      "7",
+		TrustedCAFile: writeCertPEM(t, root.Raw),
+		OCSP:          true,
+		OCSPWarning:   1440,
+		OCSPCritical:  360,
+		Timeout:       5,
+	}
+	status, err := executeCheck(nil)
+	if err != nil {
+		t.Fatalf("executeCheck() unexpected error: %v", err)
+	}
+	if status != sensu.CheckStateCritical {
+		t.Errorf("executeCheck() status = %v, want %v", status, sensu.CheckStateCritical)
+	}
+}
+
+// TestHasMustStaple tests detection of the TLS Feature status_request extension.
+func TestHasMustStaple(t *testing.T) {
+	now := time.Now()
+	ca, caKey := issueCert(t, caTemplate(1, "Test CA", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), nil, nil)
+
+	plain, _ := issueCert(t, leafTemplate(2, "www.example.com", now.AddDate(0, 3, 0)), ca, caKey)
+	if hasMustStaple(plain) {
+		t.Error("hasMustStaple() = true for a certificate without TLS Feature")
+	}
+	mustStaple, _ := issueCert(t, mustStapleTemplate(t, 3, "www.example.com", now.AddDate(0, 3, 0)), ca, caKey)
+	if !hasMustStaple(mustStaple) {
+		t.Error("hasMustStaple() = false for a Must-Staple certificate")
+	}
+}
+
+// TestCheckStaple tests evaluation of stapled OCSP responses.
+func TestCheckStaple(t *testing.T) {
+	now := time.Now()
+	ca, caKey := issueCert(t, caTemplate(1, "Test CA", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), nil, nil)
+	plain, _ := issueCert(t, leafTemplate(2, "www.example.com", now.AddDate(0, 3, 0)), ca, caKey)
+	mustStaple, _ := issueCert(t, mustStapleTemplate(t, 3, "www.example.com", now.AddDate(0, 3, 0)), ca, caKey)
+
+	staple := func(cert *x509.Certificate, status int, nextUpdate time.Duration) []byte {
+		resp, err := signOCSPResponse(ca, caKey, cert.SerialNumber, status, now.Add(nextUpdate))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		leaf       *x509.Certificate
+		staple     []byte
+		wantStatus int
+		wantErr    bool
+	}{
+		{name: "no staple", leaf: plain, wantStatus: sensu.CheckStateOK},
+		{name: "no staple with Must-Staple", leaf: mustStaple, wantStatus: sensu.CheckStateCritical},
+		{name: "good staple", leaf: plain, staple: staple(plain, ocsp.Good, 48*time.Hour), wantStatus: sensu.CheckStateOK},
+		{name: "good staple with Must-Staple", leaf: mustStaple, staple: staple(mustStaple, ocsp.Good, 48*time.Hour), wantStatus: sensu.CheckStateOK},
+		{name: "stale staple with Must-Staple", leaf: mustStaple, staple: staple(mustStaple, ocsp.Good, -time.Hour), wantStatus: sensu.CheckStateCritical},
+		{name: "revoked staple", leaf: plain, staple: staple(plain, ocsp.Revoked, 48*time.Hour), wantStatus: sensu.CheckStateCritical},
+		{name: "staple for another certificate", leaf: plain, staple: staple(mustStaple, ocsp.Good, 48*time.Hour), wantStatus: sensu.CheckStateCritical, wantErr: true},
+		{name: "malformed staple", leaf: plain, staple: []byte("not ocsp"), wantStatus: sensu.CheckStateCritical, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{Host: "www.example.com", OCSPWarning: 1440, OCSPCritical: 360}
+			status, _, err := checkStaple([]*x509.Certificate{tt.leaf, ca}, nil, tt.staple)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkStaple() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("checkStaple() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckMustStaple runs the full check against servers presenting a
+// Must-Staple certificate with and without a staple.
+func TestExecuteCheckMustStaple(t *testing.T) {
+	now := time.Now()
+	root, rootKey := issueCert(t, caTemplate(1, "Test Root", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), nil, nil)
+	intermediate, intKey := issueCert(t, caTemplate(2, "Test Intermediate", now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)), root, rootKey)
+
+	tmpl := mustStapleTemplate(t, 3, "localhost", now.AddDate(0, 6, 0))
+	tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	leaf, leafKey := issueCert(t, tmpl, intermediate, intKey)
+	good, err := signOCSPResponse(intermediate, intKey, leaf.SerialNumber, ocsp.Good, now.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		staple     []byte
+		wantStatus int
+	}{
+		{name: "staple missing", wantStatus: sensu.CheckStateCritical},
+		{name: "staple current", staple: good, wantStatus: sensu.CheckStateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCert := tls.Certificate{Certificate: [][]byte{leaf.Raw, intermediate.Raw}, PrivateKey: leafKey, OCSPStaple: tt.staple}
+			l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCert}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = l.Close() }()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				_ = conn.(*tls.Conn).Handshake()
+				time.Sleep(50 * time.Millisecond)
+				_ = conn.Close()
+			}()
+
+			plugin = Config{
+				Host:          "127.0.0.1",
+				Port:          l.Addr().(*net.TCPAddr).Port,
+				Warning:       "14",
+				Critical:      "7",
+				TrustedCAFile: writeCertPEM(t, root.Raw),
+				OCSPWarning:   1440,
+				OCSPCritical:  360,
+				Timeout:       5,
+			}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("executeCheck() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// mustStapleTemplate returns a server certificate template carrying the TLS
+// Feature status_request extension.
+func mustStapleTemplate(t *testing.T, serial int64, dnsName string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	value, err := asn1.Marshal([]int{tlsFeatureStatusRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := leafTemplate(serial, dnsName, notAfter)
+	tmpl.ExtraExtensions = []pkix.Extension{{Id: oidTLSFeature, Value: value}}
+	return tmpl
+}
+
+// startOCSPResponder serves OCSP responses signed by signer. A non-zero
+// httpStatus makes every request fail with that status instead.
+func startOCSPResponder(t *testing.T, signer *x509.Certificate, signerKey crypto.Signer, httpStatus int, answer func(*ocsp.Request) (int, time.Time)) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if httpStatus != 0 {
+			w.WriteHeader(httpStatus)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/ocsp-request") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status, nextUpdate := answer(req)
+		resp, err := signOCSPResponse(signer, signerKey, req.SerialNumber, status, nextUpdate)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	}))
+}
+
+// signOCSPResponse creates an OCSP response for serial signed directly by signer.
+func signOCSPResponse(signer *x509.Certificate, signerKey crypto.Signer, serial *big.Int, status int, nextUpdate time.Time) ([]byte, error) {
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: serial,
+		ThisUpdate:   nextUpdate.Add(-72 * time.Hour),
+		NextUpdate:   nextUpdate,
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Hour)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	return ocsp.CreateResponse(signer, signer, template, signerKey)
+}
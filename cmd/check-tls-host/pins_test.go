@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/pins"
+)
+
+// TestExecuteCheckWithPins tests that a pin mismatch raises an otherwise healthy check to critical.
+func TestExecuteCheckWithPins(t *testing.T) {
+	host, port, certFile, cleanup := startTLSServer(t, 365)
+	defer cleanup()
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	served, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		pin        string
+		wantStatus int
+	}{
+		{"match", pins.SPKIPin(served), sensu.CheckStateOK},
+		{"mismatch", "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)), sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{
+				Host:                     host,
+				Port:                     port,
+				Warning:                  "14",
+				Critical:                 "7",
+				InsecureSkipVerify:       true,
+				SkipChainVerification:    true,
+				SkipHostnameVerification: true,
+				PinSPKI:                  []string{tt.pin},
+				Timeout:                  5,
+			}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("executeCheck() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+)
+
+// TestExecuteCheckWithPolicy tests that a weak server key is critical.
+func TestExecuteCheckWithPolicy(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := leafTemplate(1, "localhost", time.Now().AddDate(0, 6, 0))
+	cert := signCert(t, template, template, key, key, x509.SHA256WithRSA)
+	host, port, cleanup := startProbeServer(t, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}})
+	defer cleanup()
+
+	tests := []struct {
+		name       string
+		minRSABits int
+		wantStatus int
+	}{
+		{"weak key", 2048, sensu.CheckStateCritical},
+		{"policy disabled", 0, sensu.CheckStateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{
+				Host:                     host,
+				Port:                     port,
+				Warning:                  "14",
+				Critical:                 "7",
+				InsecureSkipVerify:       true,
+				SkipChainVerification:    true,
+				SkipHostnameVerification: true,
+				MinRSABits:               tt.minRSABits,
+				Timeout:                  5,
+			}
+			var status int
+			var err error
+			output := captureStdout(t, func() { status, err = executeCheck(nil) })
+			if err != nil {
+				t.Fatalf("executeCheck() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+			if want := expiry.StateLabel(tt.wantStatus) + ": "; !strings.HasPrefix(output, want) {
+				t.Errorf("executeCheck() output = %q, want the headline to start with %q", output, want)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckPolicyVerifiedPath tests that the trusted root of the
+// verified path is only held to the policy with --verified-path-policy.
+func TestExecuteCheckPolicyVerifiedPath(t *testing.T) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := caTemplate(1, "Weak Root", time.Now().AddDate(-1, 0, 0), time.Now().AddDate(5, 0, 0))
+	root := signCert(t, rootTemplate, rootTemplate, rootKey, rootKey, x509.SHA256WithRSA)
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := signCert(t, leafTemplate(2, "localhost", time.Now().AddDate(0, 6, 0)), root, leafKey, rootKey, x509.SHA256WithRSA)
+	host, port, cleanup := startProbeServer(t, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: leafKey}}})
+	defer cleanup()
+	caFile := writeCertPEM(t, root.Raw)
+
+	tests := []struct {
+		name         string
+		verifiedPath bool
+		wantStatus   int
+	}{
+		{"served chain only", false, sensu.CheckStateOK},
+		{"verified path", true, sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{
+				Host:                     host,
+				Port:                     port,
+				Warning:                  "14",
+				Critical:                 "7",
+				SkipHostnameVerification: true,
+				TrustedCAFile:            caFile,
+				MinRSABits:               2048,
+				VerifiedPathPolicy:       tt.verifiedPath,
+				Timeout:                  5,
+			}
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Fatalf("executeCheck() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// signCert issues template for key's public key, signed by parentKey with algorithm.
+func signCert(t *testing.T, template, parent *x509.Certificate, key, parentKey crypto.Signer, algorithm x509.SignatureAlgorithm) *x509.Certificate {
+	t.Helper()
+	template.SignatureAlgorithm = algorithm
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
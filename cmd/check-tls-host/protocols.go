@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+)
+
+// tlsVersions are the protocol versions --protocols probes, oldest first.
+var tlsVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// parseTLSVersion accepts a version as "1.2", "TLS1.2" or "TLS 1.2".
+func parseTLSVersion(name string) (uint16, error) {
+	trimmed := strings.TrimSpace(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "TLS"))
+	for _, version := range tlsVersions {
+		if trimmed == strings.TrimPrefix(tls.VersionName(version), "TLS ") {
+			return version, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown TLS version %q (use 1.0, 1.1, 1.2 or 1.3)", name)
+}
+
+// parseTLSVersions parses the versions given to option.
+func parseTLSVersions(option string, names []string) (map[uint16]bool, error) {
+	versions := map[uint16]bool{}
+	for _, name := range names {
+		version, err := parseTLSVersion(name)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", option, err)
+		}
+		versions[version] = true
+	}
+	return versions, nil
+}
+
+// allCipherSuites returns every cipher suite crypto/tls implements, including
+// the insecure ones, so a version is not missed only because the server offers
+// nothing but legacy suites for it.
+func allCipherSuites() []uint16 {
+	var ids []uint16
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		ids = append(ids, suite.ID)
+	}
+	return ids
+}
+
+// probeHandshake opens a new connection and attempts a handshake with cfg. A
+// handshake the server refuses is reported as not accepted; only failures to
+// reach the server or complete --starttls are returned as errors. Probes do
+// not verify the certificate, which the main handshake already does.
+func probeHandshake(cfg *tls.Config, timeout time.Duration) (tls.ConnectionState, bool, error) {
+	conn, _, err := dialServer(timeout)
+	if err != nil {
+		return tls.ConnectionState{}, false, err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	cfg.ServerName = plugin.Host
+	cfg.InsecureSkipVerify = true //nolint:gosec
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return tls.ConnectionState{}, false, nil
+	}
+	return tlsConn.ConnectionState(), true, nil
+}
+
+// checkProtocols runs a handshake pinned to each TLS version and reports which
+// the server accepts. An accepted version in --protocols-deny is critical and a
+// version in --protocols-require that is not accepted is a warning.
+func checkProtocols(timeout time.Duration) (int, []string, error) {
+	denied, err := parseTLSVersions("--protocols-deny", plugin.ProtocolsDeny)
+	if err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+	required, err := parseTLSVersions("--protocols-require", plugin.ProtocolsRequire)
+	if err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+	certificates, err := clientCertificates()
+	if err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+
+	var accepted, rejected, problems []string
+	state := sensu.CheckStateOK
+	for _, version := range tlsVersions {
+		cfg := &tls.Config{MinVersion: version, MaxVersion: version, CipherSuites: allCipherSuites(), Certificates: certificates}
+		_, ok, err := probeHandshake(cfg, timeout)
+		if err != nil {
+			return sensu.CheckStateCritical, nil, fmt.Errorf("probing %v: %v", tls.VersionName(version), err)
+		}
+		name := tls.VersionName(version)
+		switch {
+		case ok && denied[version]:
+			state = sensu.CheckStateCritical
+			problems = append(problems, fmt.Sprintf("critical: %v accepted, denied by --protocols-deny", name))
+		case !ok && required[version]:
+			if state < sensu.CheckStateWarning {
+				state = sensu.CheckStateWarning
+			}
+			problems = append(problems, fmt.Sprintf("warning: %v not accepted, required by --protocols-require", name))
+		}
+		if ok {
+			accepted = append(accepted, name)
+		} else {
+			rejected = append(rejected, name)
+		}
+	}
+
+	if len(accepted) == 0 {
+		accepted = []string{"none"}
+	}
+	if len(rejected) == 0 {
+		rejected = []string{"none"}
+	}
+	lines := []string{fmt.Sprintf("%v: %v accepts %v; rejects %v", expiry.StateLabel(state), plugin.Host, strings.Join(accepted, ", "), strings.Join(rejected, ", "))}
+	return state, append(lines, problems...), nil
+}
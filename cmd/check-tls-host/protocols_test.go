@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// TestParseTLSVersion tests the accepted spellings of a TLS version.
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    uint16
+		wantErr bool
+	}{
+		{"1.0", tls.VersionTLS10, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"TLS1.2", tls.VersionTLS12, false},
+		{"tls 1.1", tls.VersionTLS11, false},
+		{"1.4", 0, true},
+		{"SSLv3", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTLSVersion(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTLSVersion(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTLSVersion(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckProtocols tests version enumeration against servers limited to a range of versions.
+func TestCheckProtocols(t *testing.T) {
+	tests := []struct {
+		name       string
+		minVersion uint16
+		maxVersion uint16
+		deny       []string
+		require    []string
+		wantStatus int
+	}{
+		{"modern server", tls.VersionTLS12, tls.VersionTLS13, []string{"1.0", "1.1"}, []string{"1.3"}, sensu.CheckStateOK},
+		{"legacy version accepted", tls.VersionTLS10, tls.VersionTLS13, []string{"1.0", "1.1"}, []string{"1.3"}, sensu.CheckStateCritical},
+		{"TLS 1.1 accepted", tls.VersionTLS11, tls.VersionTLS12, []string{"1.0", "1.1"}, nil, sensu.CheckStateCritical},
+		{"required version missing", tls.VersionTLS12, tls.VersionTLS12, []string{"1.0", "1.1"}, []string{"1.3"}, sensu.CheckStateWarning},
+		{"no policy", tls.VersionTLS10, tls.VersionTLS12, nil, nil, sensu.CheckStateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, cleanup := startVersionServer(t, tt.minVersion, tt.maxVersion)
+			defer cleanup()
+			plugin = Config{Host: host, Port: port, Timeout: 5, ProtocolsDeny: tt.deny, ProtocolsRequire: tt.require}
+			status, _, err := checkProtocols(5 * time.Second)
+			if err != nil {
+				t.Fatalf("checkProtocols() unexpected error: %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("checkProtocols() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckWithProtocols tests that --protocols raises the state of an otherwise healthy check.
+func TestExecuteCheckWithProtocols(t *testing.T) {
+	host, port, cleanup := startVersionServer(t, tls.VersionTLS10, tls.VersionTLS13)
+	defer cleanup()
+	plugin = Config{
+		Host:                     host,
+		Port:                     port,
+		Warning:                  "14",
+		Critical:                 "7",
+		InsecureSkipVerify:       true,
+		SkipChainVerification:    true,
+		SkipHostnameVerification: true,
+		Protocols:                true,
+		ProtocolsDeny:            []string{"1.0"},
+		Timeout:                  5,
+	}
+	status, err := executeCheck(nil)
+	if err != nil {
+		t.Fatalf("executeCheck() unexpected error: %v", err)
+	}
+	if status != sensu.CheckStateCritical {
+		t.Errorf("executeCheck() status = %v, want %v", status, sensu.CheckStateCritical)
+	}
+}
+
+// TestCheckProtocolsConnectionFailure tests that an unreachable server is an error, not a rejected version.
+func TestCheckProtocolsConnectionFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	plugin = Config{Host: "127.0.0.1", Port: port, Timeout: 5}
+	status, _, err := checkProtocols(5 * time.Second)
+	if err == nil || !strings.Contains(err.Error(), "connection failed") {
+		t.Errorf("checkProtocols() error = %v, want connection failure", err)
+	}
+	if status != sensu.CheckStateCritical {
+		t.Errorf("checkProtocols() status = %v, want %v", status, sensu.CheckStateCritical)
+	}
+}
+
+// startVersionServer starts a TLS server that only accepts versions from
+// minVersion to maxVersion, with every cipher suite crypto/tls implements.
+func startVersionServer(t *testing.T, minVersion, maxVersion uint16) (host string, port int, cleanup func()) {
+	t.Helper()
+	return startProbeServer(t, &tls.Config{MinVersion: minVersion, MaxVersion: maxVersion, CipherSuites: allCipherSuites()})
+}
+
+// startProbeServer starts a TLS server with cfg, and a fresh certificate unless
+// cfg has one, that completes a handshake on each connection and closes it.
+func startProbeServer(t *testing.T, cfg *tls.Config) (host string, port int, cleanup func()) {
+	t.Helper()
+	if len(cfg.Certificates) == 0 {
+		certDER, priv := generateCert(t, 365)
+		cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: priv}}
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				_ = c.(*tls.Conn).Handshake()
+				_ = c.Close()
+			}(conn)
+		}
+	}()
+	return "127.0.0.1", l.Addr().(*net.TCPAddr).Port, func() { _ = l.Close() }
+}
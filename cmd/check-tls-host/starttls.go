@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+)
+
+// starttlsNegotiator performs the plaintext part of a STARTTLS upgrade on conn,
+// leaving it ready for the TLS handshake. Protocols that advertise extensions
+// before the upgrade return them so they can be reported.
+type starttlsNegotiator func(conn net.Conn) ([]string, error)
+
+// starttlsNegotiators maps each supported --starttls protocol to its negotiator.
+var starttlsNegotiators = map[string]starttlsNegotiator{
+	"smtp":     starttlsSMTP,
+	"imap":     starttlsIMAP,
+	"pop3":     starttlsPOP3,
+	"ftp":      starttlsFTP,
+	"ldap":     starttlsLDAP,
+	"xmpp":     starttlsXMPP,
+	"postgres": starttlsPostgres,
+	"mysql":    starttlsMySQL,
+}
+
+// starttlsProtocols returns the supported --starttls protocol names in sorted order.
+func starttlsProtocols() []string {
+	names := make([]string, 0, len(starttlsNegotiators))
+	for name := range starttlsNegotiators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// starttlsSMTP runs an ESMTP dialogue (RFC 3207): it reads the possibly
+// multi-line 220 greeting, sends EHLO, checks that STARTTLS is advertised and
+// issues it. The EHLO extensions offered by the server are returned.
+func starttlsSMTP(conn net.Conn) ([]string, error) {
+	r := textproto.NewReader(bufio.NewReader(conn))
+	if _, _, err := r.ReadResponse(220); err != nil {
+		return nil, fmt.Errorf("expected SMTP 220 banner: %v", err)
+	}
+	if _, err := fmt.Fprintf(conn, "EHLO %s\r\n", ehloName()); err != nil {
+		return nil, fmt.Errorf("sending EHLO: %v", err)
+	}
+	_, msg, err := r.ReadResponse(250)
+	if err != nil {
+		return nil, fmt.Errorf("expected SMTP 250 after EHLO: %v", err)
+	}
+
+	// The first line of the EHLO reply is the server greeting, the rest are extensions.
+	var extensions []string
+	offered := false
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		extensions = append(extensions, line)
+		if strings.EqualFold(strings.Fields(line)[0], "STARTTLS") {
+			offered = true
+		}
+	}
+	if !offered {
+		return extensions, fmt.Errorf("SMTP server does not advertise STARTTLS (extensions: %v)", strings.Join(extensions, ", "))
+	}
+
+	if _, err := fmt.Fprintf(conn, "STARTTLS\r\n"); err != nil {
+		return extensions, fmt.Errorf("sending STARTTLS: %v", err)
+	}
+	if _, _, err := r.ReadResponse(220); err != nil {
+		return extensions, fmt.Errorf("expected SMTP 220 after STARTTLS: %v", err)
+	}
+	return extensions, nil
+}
+
+// ehloName returns the client name to announce in EHLO.
+func ehloName() string {
+	if plugin.EHLOName != "" {
+		return plugin.EHLOName
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "localhost"
+}
+
+// starttlsIMAP reads the IMAP greeting, requests CAPABILITY to check that
+// STARTTLS is available and then issues it (RFC 3501). The capabilities
+// reported by the server are returned.
+func starttlsIMAP(conn net.Conn) ([]string, error) {
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading IMAP banner: %v", err)
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return nil, fmt.Errorf("expected IMAP '* OK' banner, got: %v", strings.TrimSpace(line))
+	}
+
+	if _, err := fmt.Fprintf(conn, "a001 CAPABILITY\r\n"); err != nil {
+		return nil, fmt.Errorf("sending CAPABILITY: %v", err)
+	}
+	var capabilities []string
+	untagged, err := readIMAPResponse(r, "a001")
+	if err != nil {
+		return nil, fmt.Errorf("reading CAPABILITY response: %v", err)
+	}
+	for _, line := range untagged {
+		if fields := strings.Fields(line); len(fields) > 1 && strings.EqualFold(fields[1], "CAPABILITY") {
+			capabilities = append(capabilities, fields[2:]...)
+		}
+	}
+	offered := false
+	for _, c := range capabilities {
+		if strings.EqualFold(c, "STARTTLS") {
+			offered = true
+		}
+	}
+	if !offered {
+		return capabilities, fmt.Errorf("IMAP server does not advertise STARTTLS (capabilities: %v)", strings.Join(capabilities, " "))
+	}
+
+	if _, err := fmt.Fprintf(conn, "a002 STARTTLS\r\n"); err != nil {
+		return capabilities, fmt.Errorf("sending STARTTLS: %v", err)
+	}
+	if _, err := readIMAPResponse(r, "a002"); err != nil {
+		return capabilities, fmt.Errorf("reading STARTTLS response: %v", err)
+	}
+	return capabilities, nil
+}
+
+// readIMAPResponse reads lines up to the tagged completion for tag and returns
+// the untagged lines seen before it. Any completion other than OK is an error.
+func readIMAPResponse(r *bufio.Reader, tag string) ([]string, error) {
+	var untagged []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, tag+" ") {
+			untagged = append(untagged, line)
+			continue
+		}
+		status := strings.TrimPrefix(line, tag+" ")
+		if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+			return untagged, fmt.Errorf("expected IMAP OK, got: %v", line)
+		}
+		return untagged, nil
+	}
+}
+
+// starttlsPOP3 negotiates TLS using the POP3 STLS command (RFC 2595).
+func starttlsPOP3(conn net.Conn) ([]string, error) {
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading POP3 banner: %v", err)
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return nil, fmt.Errorf("expected POP3 '+OK' banner, got: %v", strings.TrimSpace(line))
+	}
+	if _, err := fmt.Fprintf(conn, "STLS\r\n"); err != nil {
+		return nil, fmt.Errorf("sending STLS: %v", err)
+	}
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading STLS response: %v", err)
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return nil, fmt.Errorf("expected POP3 '+OK' after STLS, got: %v", strings.TrimSpace(line))
+	}
+	return nil, nil
+}
+
+// starttlsFTP negotiates TLS using the FTP AUTH TLS command (RFC 4217).
+// Multi-line replies such as "220-" banners are handled by textproto.
+func starttlsFTP(conn net.Conn) ([]string, error) {
+	r := textproto.NewReader(bufio.NewReader(conn))
+	if _, _, err := r.ReadResponse(220); err != nil {
+		return nil, fmt.Errorf("expected FTP 220 banner: %v", err)
+	}
+	if _, err := fmt.Fprintf(conn, "AUTH TLS\r\n"); err != nil {
+		return nil, fmt.Errorf("sending AUTH TLS: %v", err)
+	}
+	if _, _, err := r.ReadResponse(234); err != nil {
+		return nil, fmt.Errorf("expected FTP 234 after AUTH TLS: %v", err)
+	}
+	return nil, nil
+}
+
+// ldapStartTLSOID is the LDAP StartTLS extended operation name (RFC 4511 section 4.14).
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// ldapMessage is the envelope of an LDAP response; ProtocolOp is decoded separately
+// because its tag identifies the operation.
+type ldapMessage struct {
+	MessageID  int
+	ProtocolOp asn1.RawValue
+}
+
+// starttlsLDAP sends the StartTLS extended request and expects a successful
+// extended response.
+func starttlsLDAP(conn net.Conn) ([]string, error) {
+	request := ldapMessage{
+		MessageID: 1,
+		ProtocolOp: asn1.RawValue{
+			Class:      asn1.ClassApplication,
+			Tag:        23, // ExtendedRequest
+			IsCompound: true,
+			Bytes:      append([]byte{0x80, byte(len(ldapStartTLSOID))}, ldapStartTLSOID...),
+		},
+	}
+	data, err := asn1.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("encoding LDAP StartTLS request: %v", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		return nil, fmt.Errorf("sending LDAP StartTLS request: %v", err)
+	}
+
+	data, err = readBERElement(conn)
+	if err != nil {
+		return nil, fmt.Errorf("reading LDAP StartTLS response: %v", err)
+	}
+	var response ldapMessage
+	if _, err := asn1.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("decoding LDAP StartTLS response: %v", err)
+	}
+	if response.ProtocolOp.Class != asn1.ClassApplication || response.ProtocolOp.Tag != 24 {
+		return nil, fmt.Errorf("expected LDAP ExtendedResponse, got tag %d", response.ProtocolOp.Tag)
+	}
+
+	var resultCode asn1.Enumerated
+	rest, err := asn1.Unmarshal(response.ProtocolOp.Bytes, &resultCode)
+	if err != nil {
+		return nil, fmt.Errorf("decoding LDAP result code: %v", err)
+	}
+	if resultCode != 0 {
+		var matchedDN, diagnostic []byte
+		if rest, err = asn1.Unmarshal(rest, &matchedDN); err == nil {
+			_, _ = asn1.Unmarshal(rest, &diagnostic)
+		}
+		return nil, fmt.Errorf("LDAP StartTLS refused with result code %d: %s", resultCode, diagnostic)
+	}
+	return nil, nil
+}
+
+// readBERElement reads exactly one BER TLV element from r, so nothing beyond
+// the LDAP response is consumed before the TLS handshake starts.
+func readBERElement(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		lenBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lenBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lenBytes...)
+		length = 0
+		for _, b := range lenBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
+
+const (
+	xmppStreamsNS = "http://etherx.jabber.org/streams"
+	xmppTLSNS     = "urn:ietf:params:xml:ns:xmpp-tls"
+)
+
+// starttlsXMPP opens a client stream to plugin.Host, checks that <starttls/> is
+// offered in the stream features and waits for <proceed/> (RFC 6120 section 5).
+func starttlsXMPP(conn net.Conn) ([]string, error) {
+	if _, err := fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='%s' version='1.0'>", plugin.Host, xmppStreamsNS); err != nil {
+		return nil, fmt.Errorf("opening XMPP stream: %v", err)
+	}
+
+	d := xml.NewDecoder(conn)
+	offered := false
+	for done := false; !done; {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("reading XMPP stream features: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == xmppTLSNS && t.Name.Local == "starttls" {
+				offered = true
+			}
+		case xml.EndElement:
+			if t.Name.Space == xmppStreamsNS && t.Name.Local == "features" {
+				done = true
+			}
+			if t.Name.Space == xmppStreamsNS && t.Name.Local == "stream" {
+				return nil, fmt.Errorf("XMPP stream closed before features were received")
+			}
+		}
+	}
+	if !offered {
+		return nil, fmt.Errorf("XMPP server does not offer STARTTLS")
+	}
+
+	if _, err := fmt.Fprintf(conn, "<starttls xmlns='%s'/>", xmppTLSNS); err != nil {
+		return nil, fmt.Errorf("sending XMPP starttls: %v", err)
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("reading XMPP starttls response: %v", err)
+		}
+		if t, ok := tok.(xml.StartElement); ok && t.Name.Space == xmppTLSNS {
+			if t.Name.Local == "proceed" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("XMPP server refused STARTTLS with <%s/>", t.Name.Local)
+		}
+	}
+}
+
+// postgresSSLRequestCode is the magic protocol version of a PostgreSQL SSLRequest packet.
+const postgresSSLRequestCode = 80877103
+
+// starttlsPostgres sends a PostgreSQL SSLRequest and expects the server to answer 'S'.
+func starttlsPostgres(conn net.Conn) ([]string, error) {
+	packet := make([]byte, 8)
+	binary.BigEndian.PutUint32(packet[0:4], 8)
+	binary.BigEndian.PutUint32(packet[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(packet); err != nil {
+		return nil, fmt.Errorf("sending PostgreSQL SSLRequest: %v", err)
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, fmt.Errorf("reading PostgreSQL SSLRequest response: %v", err)
+	}
+	switch reply[0] {
+	case 'S':
+		return nil, nil
+	case 'N':
+		return nil, fmt.Errorf("PostgreSQL server does not support SSL")
+	default:
+		return nil, fmt.Errorf("unexpected PostgreSQL SSLRequest response: %q", reply[0])
+	}
+}
+
+// MySQL capability flags used in the SSL handshake.
+const (
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+)
+
+// starttlsMySQL reads the MySQL initial handshake, checks that the server has the
+// CLIENT_SSL capability and replies with an SSLRequest packet.
+func starttlsMySQL(conn net.Conn) ([]string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("reading MySQL handshake: %v", err)
+	}
+	payload := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, fmt.Errorf("reading MySQL handshake: %v", err)
+	}
+	if len(payload) > 0 && payload[0] == 0xff {
+		msg := ""
+		if len(payload) > 3 {
+			msg = string(payload[3:])
+		}
+		return nil, fmt.Errorf("MySQL server returned error: %v", msg)
+	}
+	if len(payload) == 0 || payload[0] != 10 {
+		return nil, fmt.Errorf("unsupported MySQL protocol version")
+	}
+
+	// protocol version, NUL-terminated server version, connection id (4),
+	// auth-plugin-data part 1 (8), filler (1), then the lower capability flags (2).
+	end := strings.IndexByte(string(payload[1:]), 0)
+	if end < 0 {
+		return nil, fmt.Errorf("malformed MySQL handshake")
+	}
+	capsOffset := 1 + end + 1 + 4 + 8 + 1
+	if len(payload) < capsOffset+2 {
+		return nil, fmt.Errorf("malformed MySQL handshake")
+	}
+	caps := binary.LittleEndian.Uint16(payload[capsOffset:])
+	if caps&mysqlClientSSL == 0 {
+		return nil, fmt.Errorf("MySQL server does not support SSL")
+	}
+
+	request := make([]byte, 4+32)
+	request[0] = 32 // payload length
+	request[3] = header[3] + 1
+	binary.LittleEndian.PutUint32(request[4:8], mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(request[8:12], 1<<24) // max packet size
+	request[12] = 0x21                                  // utf8_general_ci
+	if _, err := conn.Write(request); err != nil {
+		return nil, fmt.Errorf("sending MySQL SSLRequest: %v", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// TestStartTLSSMTP tests the SMTP EHLO/STARTTLS dialogue.
+func TestStartTLSSMTP(t *testing.T) {
+	t.Run("successful handshake with multi-line replies", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		ehlo := make(chan string, 1)
+		go func() {
+			// SMTP server side: multi-line banner, EHLO capabilities, then 220 for STARTTLS
+			_, _ = fmt.Fprintf(server, "220-mail.example.com ESMTP Postfix\r\n220 Unauthorised access prohibited\r\n")
+			r := bufio.NewReader(server)
+			line, _ := r.ReadString('\n')
+			ehlo <- strings.TrimSpace(line)
+			_, _ = fmt.Fprintf(server, "250-mail.example.com\r\n250-PIPELINING\r\n250-SIZE 10240000\r\n250-STARTTLS\r\n250 8BITMIME\r\n")
+			line, _ = r.ReadString('\n')
+			if strings.TrimSpace(line) == "STARTTLS" {
+				_, _ = fmt.Fprintf(server, "220 2.0.0 Ready to start TLS\r\n")
+			}
+		}()
+
+		plugin = Config{EHLOName: "monitor.example.com"}
+		extensions, err := starttlsSMTP(client)
+		if err != nil {
+			t.Fatalf("starttlsSMTP() unexpected error: %v", err)
+		}
+		if got := <-ehlo; got != "EHLO monitor.example.com" {
+			t.Errorf("starttlsSMTP() sent %q, want EHLO with configured name", got)
+		}
+		want := []string{"PIPELINING", "SIZE 10240000", "STARTTLS", "8BITMIME"}
+		if strings.Join(extensions, ",") != strings.Join(want, ",") {
+			t.Errorf("starttlsSMTP() extensions = %v, want %v", extensions, want)
+		}
+	})
+
+	t.Run("bad initial banner", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "421 Service not available\r\n")
+		}()
+
+		if _, err := starttlsSMTP(client); err == nil {
+			t.Error("starttlsSMTP() expected error for non-220 banner")
+		}
+	})
+
+	t.Run("EHLO rejected", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "220 ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "502 Command not implemented\r\n")
+		}()
+
+		if _, err := starttlsSMTP(client); err == nil {
+			t.Error("starttlsSMTP() expected error for rejected EHLO")
+		}
+	})
+
+	t.Run("STARTTLS not advertised", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "220 ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "250-mail.example.com\r\n250 PIPELINING\r\n")
+		}()
+
+		_, err := starttlsSMTP(client)
+		if err == nil {
+			t.Fatal("starttlsSMTP() expected error when STARTTLS is not advertised")
+		}
+		if !strings.Contains(err.Error(), "PIPELINING") {
+			t.Errorf("starttlsSMTP() error = %q, want it to list the offered extensions", err.Error())
+		}
+	})
+
+	t.Run("bad STARTTLS response", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "220 ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "250-mail.example.com\r\n250 STARTTLS\r\n")
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "454 TLS not available\r\n")
+		}()
+
+		if _, err := starttlsSMTP(client); err == nil {
+			t.Error("starttlsSMTP() expected error for non-220 STARTTLS response")
+		}
+	})
+}
+
+// TestStartTLSIMAP tests the IMAP CAPABILITY/STARTTLS dialogue.
+func TestStartTLSIMAP(t *testing.T) {
+	t.Run("successful handshake", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "* OK [CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED] Dovecot ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "* CAPABILITY IMAP4rev1 SASL-IR STARTTLS LOGINDISABLED\r\na001 OK Pre-login capabilities listed, post-login capabilities have more.\r\n")
+			line, _ := r.ReadString('\n')
+			if strings.TrimSpace(line) == "a002 STARTTLS" {
+				_, _ = fmt.Fprintf(server, "a002 OK Begin TLS negotiation now.\r\n")
+			}
+		}()
+
+		capabilities, err := starttlsIMAP(client)
+		if err != nil {
+			t.Fatalf("starttlsIMAP() unexpected error: %v", err)
+		}
+		if len(capabilities) != 4 {
+			t.Errorf("starttlsIMAP() capabilities = %v, want 4 entries", capabilities)
+		}
+	})
+
+	t.Run("other OK texts are accepted", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "* OK The Microsoft Exchange IMAP4 service is ready.\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "* CAPABILITY IMAP4 IMAP4rev1 AUTH=NTLM STARTTLS\r\na001 OK CAPABILITY completed.\r\n")
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "a002 OK Begin TLS negotiation now.\r\n")
+		}()
+
+		if _, err := starttlsIMAP(client); err != nil {
+			t.Errorf("starttlsIMAP() unexpected error: %v", err)
+		}
+	})
+
+	t.Run("bad initial banner", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "* BYE Server shutting down\r\n")
+		}()
+
+		if _, err := starttlsIMAP(client); err == nil {
+			t.Error("starttlsIMAP() expected error for non-OK banner")
+		}
+	})
+
+	t.Run("STARTTLS not advertised", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "* OK ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\na001 OK done\r\n")
+		}()
+
+		if _, err := starttlsIMAP(client); err == nil {
+			t.Error("starttlsIMAP() expected error when STARTTLS is not advertised")
+		}
+	})
+
+	t.Run("bad STARTTLS response", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "* OK ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "* CAPABILITY IMAP4rev1 STARTTLS\r\na001 OK done\r\n")
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "a002 NO TLS not supported\r\n")
+		}()
+
+		if _, err := starttlsIMAP(client); err == nil {
+			t.Error("starttlsIMAP() expected error for NO response")
+		}
+	})
+}
+
+// TestExecuteCheckSMTPStartTLS runs the full check against a fake SMTP server that upgrades to TLS.
+func TestExecuteCheckSMTPStartTLS(t *testing.T) {
+	certDER, priv := generateCert(t, 365)
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{certDER}, PrivateKey: priv}}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = fmt.Fprintf(conn, "220 mail.example.com ESMTP\r\n")
+		r := bufio.NewReader(conn)
+		_, _ = r.ReadString('\n')
+		_, _ = fmt.Fprintf(conn, "250-mail.example.com\r\n250-STARTTLS\r\n250 SIZE 1000\r\n")
+		_, _ = r.ReadString('\n')
+		_, _ = fmt.Fprintf(conn, "220 Ready to start TLS\r\n")
+		tc := tls.Server(conn, tlsCfg)
+		_ = tc.Handshake()
+		time.Sleep(50 * time.Millisecond)
+	}()
+
+	plugin = Config{
+		Host:          "127.0.0.1",
+		Port:          l.Addr().(*net.TCPAddr).Port,
+		Warning:       "14",
+		Critical:      "7",
+		TrustedCAFile: writeCertPEM(t, certDER),
+		StartTLS:      "smtp",
+		Timeout:       5,
+	}
+	status, err := executeCheck(nil)
+	if err != nil {
+		t.Fatalf("executeCheck() unexpected error: %v", err)
+	}
+	if status != sensu.CheckStateOK {
+		t.Errorf("executeCheck() status = %v, want %v", status, sensu.CheckStateOK)
+	}
+}
+
+// TestStartTLSPOP3 tests the POP3 STLS handshake function.
+func TestStartTLSPOP3(t *testing.T) {
+	t.Run("successful handshake", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "+OK Dovecot ready.\r\n")
+			r := bufio.NewReader(server)
+			line, _ := r.ReadString('\n')
+			if strings.TrimSpace(line) == "STLS" {
+				_, _ = fmt.Fprintf(server, "+OK Begin TLS negotiation now.\r\n")
+			}
+		}()
+
+		if _, err := starttlsPOP3(client); err != nil {
+			t.Errorf("starttlsPOP3() unexpected error: %v", err)
+		}
+	})
+
+	t.Run("STLS refused", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "+OK ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "-ERR Command not permitted\r\n")
+		}()
+
+		if _, err := starttlsPOP3(client); err == nil {
+			t.Error("starttlsPOP3() expected error for -ERR response")
+		}
+	})
+}
+
+// TestStartTLSFTP tests the FTP AUTH TLS handshake function.
+func TestStartTLSFTP(t *testing.T) {
+	t.Run("successful handshake with multi-line banner", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "220-Welcome to example.com\r\n220-Authorised users only\r\n220 FTP ready\r\n")
+			r := bufio.NewReader(server)
+			line, _ := r.ReadString('\n')
+			if strings.TrimSpace(line) == "AUTH TLS" {
+				_, _ = fmt.Fprintf(server, "234 Proceed with negotiation.\r\n")
+			}
+		}()
+
+		if _, err := starttlsFTP(client); err != nil {
+			t.Errorf("starttlsFTP() unexpected error: %v", err)
+		}
+	})
+
+	t.Run("AUTH TLS refused", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = fmt.Fprintf(server, "220 FTP ready\r\n")
+			r := bufio.NewReader(server)
+			_, _ = r.ReadString('\n')
+			_, _ = fmt.Fprintf(server, "530 Please login with USER and PASS.\r\n")
+		}()
+
+		if _, err := starttlsFTP(client); err == nil {
+			t.Error("starttlsFTP() expected error for 530 response")
+		}
+	})
+}
+
+// TestStartTLSLDAP tests the LDAP StartTLS extended operation.
+func TestStartTLSLDAP(t *testing.T) {
+	ldapResponse := func(resultCode byte, diagnostic string) []byte {
+		op := []byte{0x0a, 0x01, resultCode, 0x04, 0x00, 0x04, byte(len(diagnostic))}
+		op = append(op, diagnostic...)
+		data, _ := asn1.Marshal(ldapMessage{
+			MessageID:  1,
+			ProtocolOp: asn1.RawValue{Class: asn1.ClassApplication, Tag: 24, IsCompound: true, Bytes: op},
+		})
+		return data
+	}
+
+	t.Run("successful handshake", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			req, err := readBERElement(server)
+			if err != nil || !strings.Contains(string(req), ldapStartTLSOID) {
+				return
+			}
+			_, _ = server.Write(ldapResponse(0, ""))
+		}()
+
+		if _, err := starttlsLDAP(client); err != nil {
+			t.Errorf("starttlsLDAP() unexpected error: %v", err)
+		}
+	})
+
+	t.Run("StartTLS refused", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = readBERElement(server)
+			_, _ = server.Write(ldapResponse(2, "unsupported extended operation"))
+		}()
+
+		_, err := starttlsLDAP(client)
+		if err == nil {
+			t.Fatal("starttlsLDAP() expected error for protocolError result")
+		}
+		if !strings.Contains(err.Error(), "unsupported extended operation") {
+			t.Errorf("starttlsLDAP() error = %q, want diagnostic message", err.Error())
+		}
+	})
+}
+
+// TestStartTLSXMPP tests the XMPP <starttls/> negotiation.
+func TestStartTLSXMPP(t *testing.T) {
+	const streamHeader = "<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' from='example.com' id='abc' version='1.0'>"
+
+	t.Run("successful handshake", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			buf := make([]byte, 4096)
+			_, _ = server.Read(buf)
+			_, _ = fmt.Fprintf(server, "%s<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>", streamHeader)
+			n, _ := server.Read(buf)
+			if strings.Contains(string(buf[:n]), "<starttls") {
+				_, _ = fmt.Fprintf(server, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+			}
+		}()
+
+		plugin = Config{Host: "example.com"}
+		if _, err := starttlsXMPP(client); err != nil {
+			t.Errorf("starttlsXMPP() unexpected error: %v", err)
+		}
+	})
+
+	t.Run("starttls not offered", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			buf := make([]byte, 4096)
+			_, _ = server.Read(buf)
+			_, _ = fmt.Fprintf(server, "%s<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/></stream:features>", streamHeader)
+		}()
+
+		plugin = Config{Host: "example.com"}
+		if _, err := starttlsXMPP(client); err == nil {
+			t.Error("starttlsXMPP() expected error when starttls is not offered")
+		}
+	})
+
+	t.Run("starttls failure", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			buf := make([]byte, 4096)
+			_, _ = server.Read(buf)
+			_, _ = fmt.Fprintf(server, "%s<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:features>", streamHeader)
+			_, _ = server.Read(buf)
+			_, _ = fmt.Fprintf(server, "<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+		}()
+
+		plugin = Config{Host: "example.com"}
+		if _, err := starttlsXMPP(client); err == nil {
+			t.Error("starttlsXMPP() expected error for <failure/> response")
+		}
+	})
+}
+
+// TestStartTLSPostgres tests the PostgreSQL SSLRequest negotiation.
+func TestStartTLSPostgres(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   byte
+		wantErr bool
+	}{
+		{"server accepts SSL", 'S', false},
+		{"server refuses SSL", 'N', true},
+		{"unexpected reply", 'E', true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() { _ = server.Close(); _ = client.Close() }()
+
+			go func() {
+				req := make([]byte, 8)
+				if _, err := io.ReadFull(server, req); err != nil {
+					return
+				}
+				if binary.BigEndian.Uint32(req[4:]) == postgresSSLRequestCode {
+					_, _ = server.Write([]byte{tt.reply})
+				}
+			}()
+
+			_, err := starttlsPostgres(client)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("starttlsPostgres() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestStartTLSMySQL tests the MySQL SSL capability handshake.
+func TestStartTLSMySQL(t *testing.T) {
+	handshake := func(caps uint16) []byte {
+		payload := []byte{10}
+		payload = append(payload, "8.0.36\x00"...)
+		payload = append(payload, 1, 0, 0, 0)    // connection id
+		payload = append(payload, "abcdefgh"...) // auth-plugin-data part 1
+		payload = append(payload, 0)             // filler
+		payload = binary.LittleEndian.AppendUint16(payload, caps)
+		packet := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}
+		return append(packet, payload...)
+	}
+
+	t.Run("successful handshake", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		gotSSL := make(chan bool, 1)
+		go func() {
+			_, _ = server.Write(handshake(0xffff))
+			req := make([]byte, 36)
+			if _, err := io.ReadFull(server, req); err != nil {
+				gotSSL <- false
+				return
+			}
+			gotSSL <- req[3] == 1 && binary.LittleEndian.Uint32(req[4:8])&mysqlClientSSL != 0
+		}()
+
+		if _, err := starttlsMySQL(client); err != nil {
+			t.Fatalf("starttlsMySQL() unexpected error: %v", err)
+		}
+		if !<-gotSSL {
+			t.Error("starttlsMySQL() did not send a valid SSLRequest packet")
+		}
+	})
+
+	t.Run("server without SSL capability", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			_, _ = server.Write(handshake(0xffff &^ mysqlClientSSL))
+		}()
+
+		if _, err := starttlsMySQL(client); err == nil {
+			t.Error("starttlsMySQL() expected error when CLIENT_SSL is not set")
+		}
+	})
+
+	t.Run("server error packet", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		go func() {
+			payload := append([]byte{0xff, 0x69, 0x04}, "Host is blocked"...)
+			_, _ = server.Write(append([]byte{byte(len(payload)), 0, 0, 0}, payload...))
+		}()
+
+		if _, err := starttlsMySQL(client); err == nil {
+			t.Error("starttlsMySQL() expected error for error packet")
+		}
+	})
+}
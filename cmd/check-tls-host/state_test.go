@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// TestExecuteCheckCertChange tests that a certificate swap between runs is
+// reported once in the --change-state state.
+func TestExecuteCheckCertChange(t *testing.T) {
+	var current atomic.Pointer[tls.Certificate]
+	serve := func(days int) {
+		certDER, priv := generateCert(t, days)
+		current.Store(&tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: priv})
+	}
+	serve(365)
+	host, port, cleanup := startProbeServer(t, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{*current.Load()}}, nil
+		},
+	})
+	defer cleanup()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	for _, run := range []struct {
+		name        string
+		swap        bool
+		changeState string
+		wantStatus  int
+	}{
+		{"first run", false, "warning", sensu.CheckStateOK},
+		{"same certificate", false, "warning", sensu.CheckStateOK},
+		{"swapped certificate", true, "warning", sensu.CheckStateWarning},
+		{"change recorded", false, "warning", sensu.CheckStateOK},
+		{"swap as critical", true, "critical", sensu.CheckStateCritical},
+	} {
+		if run.swap {
+			serve(200)
+		}
+		plugin = Config{
+			Host:                     host,
+			Port:                     port,
+			Warning:                  "14",
+			Critical:                 "7",
+			InsecureSkipVerify:       true,
+			SkipChainVerification:    true,
+			SkipHostnameVerification: true,
+			StateFile:                stateFile,
+			ChangeState:              run.changeState,
+			Timeout:                  5,
+		}
+		status, err := executeCheck(nil)
+		if err != nil {
+			t.Fatalf("%v: executeCheck() unexpected error: %v", run.name, err)
+		}
+		if status != run.wantStatus {
+			t.Errorf("%v: executeCheck() status = %v, want %v", run.name, status, run.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // the keystore integrity digest is defined as SHA-1
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic   = 0xFEEDFEED
+	jceksMagic = 0xCECECECE
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+	jksSecretKeyTag   = 3
+
+	// jksIntegritySalt is mixed into the keystore digest after the password.
+	jksIntegritySalt = "Mighty Aphrodite"
+)
+
+// keystoreEntry is one alias in a keystore: a private key entry with its
+// certificate chain, or a trusted certificate entry.
+type keystoreEntry struct {
+	alias      string
+	created    time.Time
+	privateKey bool
+	chain      []*x509.Certificate
+}
+
+// keystoreReader decodes the big-endian primitives of the JKS format.
+type keystoreReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (k *keystoreReader) uint32() uint32 {
+	var v uint32
+	if k.err == nil {
+		k.err = binary.Read(k.r, binary.BigEndian, &v)
+	}
+	return v
+}
+
+func (k *keystoreReader) int64() int64 {
+	var v int64
+	if k.err == nil {
+		k.err = binary.Read(k.r, binary.BigEndian, &v)
+	}
+	return v
+}
+
+func (k *keystoreReader) bytes(n int) []byte {
+	if k.err != nil {
+		return nil
+	}
+	if n < 0 || n > k.r.Len() {
+		k.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := make([]byte, n)
+	_, k.err = io.ReadFull(k.r, b)
+	return b
+}
+
+// utf reads a Java DataOutput.writeUTF string.
+func (k *keystoreReader) utf() string {
+	var n uint16
+	if k.err == nil {
+		k.err = binary.Read(k.r, binary.BigEndian, &n)
+	}
+	return string(k.bytes(int(n)))
+}
+
+// certificate reads one encoded certificate; version 1 stores omit the type.
+func (k *keystoreReader) certificate(version uint32) (*x509.Certificate, error) {
+	if version == 2 {
+		if certType := k.utf(); k.err == nil && certType != "X.509" {
+			return nil, fmt.Errorf("unsupported certificate type %q", certType)
+		}
+	}
+	der := k.bytes(int(k.uint32()))
+	if k.err != nil {
+		return nil, k.err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// parseJKS decodes a JKS or JCEKS keystore and verifies its integrity digest
+// with password. Private keys are not decrypted; only certificates are read.
+func parseJKS(data []byte, password string) ([]keystoreEntry, error) {
+	if len(data) < sha1.Size {
+		return nil, fmt.Errorf("keystore is truncated")
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if subtle.ConstantTimeCompare(keystoreDigest(body, password), digest) != 1 {
+		return nil, fmt.Errorf("keystore integrity check failed: wrong password or corrupted keystore")
+	}
+
+	k := &keystoreReader{r: bytes.NewReader(body)}
+	magic, version, count := k.uint32(), k.uint32(), k.uint32()
+	if k.err != nil {
+		return nil, fmt.Errorf("reading keystore header: %v", k.err)
+	}
+	if magic != jksMagic && magic != jceksMagic {
+		return nil, fmt.Errorf("not a JKS or JCEKS keystore (magic %08X)", magic)
+	}
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported keystore version %d", version)
+	}
+
+	// Every entry takes at least its tag, an alias length and a date.
+	if maxEntries := uint32(k.r.Len() / 14); count > maxEntries {
+		return nil, fmt.Errorf("keystore claims %d entries but has room for at most %d", count, maxEntries)
+	}
+	entries := make([]keystoreEntry, 0, count)
+	for i := uint32(0); i < count; i++ {
+		tag := k.uint32()
+		entry := keystoreEntry{alias: k.utf(), created: time.UnixMilli(k.int64())}
+		if k.err != nil {
+			return nil, fmt.Errorf("reading keystore entry %d: %v", i+1, k.err)
+		}
+		switch tag {
+		case jksPrivateKeyTag:
+			entry.privateKey = true
+			k.bytes(int(k.uint32())) // encrypted private key
+			chainLength := k.uint32()
+			for j := uint32(0); j < chainLength && k.err == nil; j++ {
+				cert, err := k.certificate(version)
+				if err != nil {
+					return nil, fmt.Errorf("reading certificate %d of alias %q: %v", j+1, entry.alias, err)
+				}
+				entry.chain = append(entry.chain, cert)
+			}
+		case jksTrustedCertTag:
+			cert, err := k.certificate(version)
+			if err != nil {
+				return nil, fmt.Errorf("reading certificate of alias %q: %v", entry.alias, err)
+			}
+			entry.chain = []*x509.Certificate{cert}
+		case jksSecretKeyTag:
+			// JCEKS secret keys are serialized SealedObjects without a length
+			// prefix and hold no certificate; read past them and move on.
+			if err := k.skipJavaObject(); err != nil {
+				return nil, fmt.Errorf("reading secret key of alias %q: %v", entry.alias, err)
+			}
+			continue
+		default:
+			return nil, fmt.Errorf("alias %q has unknown entry type %d", entry.alias, tag)
+		}
+		if k.err != nil {
+			return nil, fmt.Errorf("reading alias %q: %v", entry.alias, k.err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// keystoreDigest computes the JKS integrity digest: SHA-1 over the password as
+// UTF-16BE, the salt and the keystore body.
+func keystoreDigest(body []byte, password string) []byte {
+	h := sha1.New() //nolint:gosec
+	for _, c := range utf16.Encode([]rune(password)) {
+		_, _ = h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	_, _ = h.Write([]byte(jksIntegritySalt))
+	_, _ = h.Write(body)
+	return h.Sum(nil)
+}
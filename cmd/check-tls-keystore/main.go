@@ -2,23 +2,32 @@ package main
 
 import (
 	"crypto/x509"
-	"encoding/pem"
+	"encoding/binary"
 	"fmt"
-	"os/exec"
+	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
 )
 
 type Config struct {
 	sensu.PluginConfig
-	Path     string
-	Alias    string
-	Password string
-	Warning  int
-	Critical int
+	Path           string
+	Alias          string
+	AllAliases     bool
+	IncludeAlias   string
+	ExcludeAlias   string
+	Password       string
+	PasswordFile   string
+	PasswordEnv    string
+	Warning        string
+	Critical       string
+	NotBeforeGrace string
 }
 
 var (
@@ -33,7 +42,7 @@ var (
 	options = []sensu.ConfigOption{
 		&sensu.PluginConfigOption[string]{
 			Argument: "path",
-			Usage:    "Path to the Java keystore file",
+			Usage:    "Path to the keystore file (JKS, JCEKS or PKCS#12, detected from its contents)",
 			Value:    &plugin.Path,
 		},
 		&sensu.PluginConfigOption[string]{
@@ -41,23 +50,54 @@ var (
 			Usage:    "Certificate alias in the keystore",
 			Value:    &plugin.Alias,
 		},
+		&sensu.PluginConfigOption[bool]{
+			Argument: "all-aliases",
+			Usage:    "Check every alias in the keystore instead of --alias",
+			Value:    &plugin.AllAliases,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "include-alias",
+			Usage:    "With --all-aliases, only check aliases matching this regular expression",
+			Value:    &plugin.IncludeAlias,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "exclude-alias",
+			Usage:    "With --all-aliases, skip aliases matching this regular expression",
+			Value:    &plugin.ExcludeAlias,
+		},
 		&sensu.PluginConfigOption[string]{
 			Argument: "password",
 			Usage:    "Keystore password",
 			Value:    &plugin.Password,
 		},
-		&sensu.PluginConfigOption[int]{
+		&sensu.PluginConfigOption[string]{
+			Argument: "password-file",
+			Usage:    "Read the keystore password from this file (a trailing newline is ignored)",
+			Value:    &plugin.PasswordFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "password-env",
+			Usage:    "Read the keystore password from this environment variable",
+			Value:    &plugin.PasswordEnv,
+		},
+		&sensu.PluginConfigOption[string]{
 			Argument:  "warning",
 			Shorthand: "w",
-			Usage:     "Days before expiry to warn",
+			Usage:     "Expiry threshold to warn: days, a duration such as 36h, or a percentage of the validity period such as 20%",
 			Value:     &plugin.Warning,
 		},
-		&sensu.PluginConfigOption[int]{
+		&sensu.PluginConfigOption[string]{
 			Argument:  "critical",
 			Shorthand: "c",
-			Usage:     "Days before expiry to go critical",
+			Usage:     "Expiry threshold to go critical: days, a duration such as 36h, or a percentage of the validity period such as 10%",
 			Value:     &plugin.Critical,
 		},
+		&sensu.PluginConfigOption[string]{
+			Argument: "not-before-grace",
+			Default:  "0s",
+			Usage:    "How far in the future a certificate's NotBefore may be before it is critical, as a duration such as 5m",
+			Value:    &plugin.NotBeforeGrace,
+		},
 	}
 )
 
@@ -70,84 +110,203 @@ func checkArgs(event *corev2.Event) (int, error) {
 	if len(plugin.Path) == 0 {
 		return sensu.CheckStateWarning, fmt.Errorf("--path is required")
 	}
-	if len(plugin.Alias) == 0 {
+	if len(plugin.Alias) == 0 && !plugin.AllAliases {
 		return sensu.CheckStateWarning, fmt.Errorf("--alias is required")
 	}
-	if len(plugin.Password) == 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--password is required")
+	if len(plugin.Alias) > 0 && plugin.AllAliases {
+		return sensu.CheckStateWarning, fmt.Errorf("--alias cannot be used with --all-aliases")
+	}
+	if (len(plugin.IncludeAlias) > 0 || len(plugin.ExcludeAlias) > 0) && !plugin.AllAliases {
+		return sensu.CheckStateWarning, fmt.Errorf("--include-alias and --exclude-alias require --all-aliases")
+	}
+	for flag, expr := range map[string]string{"--include-alias": plugin.IncludeAlias, "--exclude-alias": plugin.ExcludeAlias} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("%v is not a valid regular expression: %v", flag, err)
+		}
 	}
-	if plugin.Critical <= 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--critical is required")
+	passwordSources := 0
+	for _, source := range []string{plugin.Password, plugin.PasswordFile, plugin.PasswordEnv} {
+		if len(source) > 0 {
+			passwordSources++
+		}
 	}
-	if plugin.Warning <= 0 {
-		return sensu.CheckStateWarning, fmt.Errorf("--warning is required")
+	if passwordSources == 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--password is required (or --password-file or --password-env)")
+	}
+	if passwordSources > 1 {
+		return sensu.CheckStateWarning, fmt.Errorf("only one of --password, --password-file and --password-env can be given")
+	}
+	expiryPolicy, err := expiry.NewPolicy(plugin.Warning, plugin.Critical, plugin.NotBeforeGrace)
+	if err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	if plugin.Warning < plugin.Critical {
+	if expiryPolicy.Warning.Less(expiryPolicy.Critical) {
 		return sensu.CheckStateWarning, fmt.Errorf("--warning cannot be less than --critical")
 	}
 	return sensu.CheckStateOK, nil
 }
 
-func getCertFromKeystore() (*x509.Certificate, error) {
-	// Export the cert in PEM format via keytool, then pipe to openssl x509 to get DER/PEM.
-	// We use exec.Command with explicit args to avoid shell injection.
-	keytool := exec.Command("keytool",
-		"-keystore", plugin.Path,
-		"-export",
-		"-alias", plugin.Alias,
-		"-storepass", plugin.Password,
-		"-rfc",
-	)
-	keytoolOut, err := keytool.Output()
-	if err != nil {
-		return nil, fmt.Errorf("keytool failed: %v", err)
+// resolvePassword returns the keystore password from --password,
+// --password-file or --password-env.
+func resolvePassword() (string, error) {
+	switch {
+	case len(plugin.PasswordFile) > 0:
+		data, err := os.ReadFile(plugin.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("reading password file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case len(plugin.PasswordEnv) > 0:
+		password, ok := os.LookupEnv(plugin.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %v is not set", plugin.PasswordEnv)
+		}
+		return password, nil
+	default:
+		return plugin.Password, nil
+	}
+}
+
+// parseKeystore detects the keystore type from its leading bytes and decodes it.
+func parseKeystore(data []byte, password string) ([]keystoreEntry, error) {
+	switch {
+	case len(data) >= 4 && (binary.BigEndian.Uint32(data) == jksMagic || binary.BigEndian.Uint32(data) == jceksMagic):
+		return parseJKS(data, password)
+	case len(data) > 0 && data[0] == 0x30: // ASN.1 SEQUENCE
+		return parsePKCS12(data, password)
+	default:
+		return nil, fmt.Errorf("unknown keystore type: not JKS, JCEKS or PKCS#12")
 	}
+}
 
-	// keytool -rfc outputs PEM. Decode it directly without needing openssl.
-	// Strip any non-PEM lines (e.g. "Certificate stored in file...")
-	pemData := extractPEM(string(keytoolOut))
-	if pemData == "" {
-		return nil, fmt.Errorf("keytool output contained no PEM certificate block")
+// loadKeystore reads and decodes the keystore at --path.
+func loadKeystore() ([]keystoreEntry, error) {
+	password, err := resolvePassword()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(plugin.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading keystore: %v", err)
 	}
+	entries, err := parseKeystore(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", plugin.Path, err)
+	}
+	return entries, nil
+}
 
-	block, _ := pem.Decode([]byte(pemData))
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM from keytool output")
+// getCertFromKeystore returns the first certificate of the --alias entry.
+// Java stores aliases in lower case, so the lookup ignores case.
+func getCertFromKeystore() (*x509.Certificate, error) {
+	entries, err := loadKeystore()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.alias, plugin.Alias) {
+			if len(entry.chain) == 0 {
+				return nil, fmt.Errorf("alias %q has no certificate", plugin.Alias)
+			}
+			return entry.chain[0], nil
+		}
 	}
-	return x509.ParseCertificate(block.Bytes)
+	return nil, fmt.Errorf("alias %q not found in %v", plugin.Alias, plugin.Path)
 }
 
-// extractPEM returns just the PEM certificate block from keytool output.
-func extractPEM(output string) string {
-	start := strings.Index(output, "-----BEGIN CERTIFICATE-----")
-	end := strings.Index(output, "-----END CERTIFICATE-----")
-	if start == -1 || end == -1 {
-		return ""
+// selectAliases returns the entries whose alias passes --include-alias and
+// --exclude-alias.
+func selectAliases(entries []keystoreEntry) []keystoreEntry {
+	include := regexp.MustCompile(plugin.IncludeAlias)
+	exclude := regexp.MustCompile(plugin.ExcludeAlias)
+	var selected []keystoreEntry
+	for _, entry := range entries {
+		if !include.MatchString(entry.alias) {
+			continue
+		}
+		if len(plugin.ExcludeAlias) > 0 && exclude.MatchString(entry.alias) {
+			continue
+		}
+		selected = append(selected, entry)
 	}
-	return output[start : end+len("-----END CERTIFICATE-----")]
+	return selected
 }
 
-func executeCheck(event *corev2.Event) (int, error) {
-	cert, err := getCertFromKeystore()
+// checkAllAliases applies the expiry thresholds to every certificate in the
+// chain of every selected alias and reports each alias by its certificate
+// closest to expiry. The state is the worst across the keystore.
+func checkAllAliases(expiryPolicy expiry.Policy) (int, error) {
+	entries, err := loadKeystore()
 	if err != nil {
 		return sensu.CheckStateCritical, err
 	}
+	entries = selectAliases(entries)
+	if len(entries) == 0 {
+		return sensu.CheckStateCritical, fmt.Errorf("no aliases in %v match the alias filters", plugin.Path)
+	}
 
 	timeNow := time.Now()
-	expiresInDays := int(cert.NotAfter.Sub(timeNow).Hours() / 24)
+	worst, closestAlias := sensu.CheckStateOK, 0
+	closestCerts := make([]*x509.Certificate, len(entries))
+	lines := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		if len(entry.chain) == 0 {
+			return sensu.CheckStateCritical, fmt.Errorf("alias %q has no certificate", entry.alias)
+		}
+		aliasState, closest := sensu.CheckStateOK, 0
+		for j, cert := range entry.chain {
+			if state, _ := expiryPolicy.State(cert, timeNow); state > aliasState {
+				aliasState = state
+			}
+			if cert.NotAfter.Before(entry.chain[closest].NotAfter) {
+				closest = j
+			}
+		}
+		closestCerts[i] = entry.chain[closest]
+		if aliasState > worst {
+			worst = aliasState
+		}
+		if closestCerts[i].NotAfter.Before(closestCerts[closestAlias].NotAfter) {
+			closestAlias = i
+		}
+		kind := "trusted cert"
+		if entry.privateKey {
+			kind = "private key"
+		}
+		line := fmt.Sprintf("%v: alias %q (%v, %d certs) %v, closest to expiry is %q", expiry.StateLabel(aliasState), entry.alias, kind,
+			len(entry.chain), expiry.DescribeValidity(closestCerts[i], timeNow), closestCerts[i].Subject.String())
+		for _, cert := range entry.chain {
+			if cert != closestCerts[i] && expiryPolicy.NotYetValid(cert, timeNow) {
+				line += fmt.Sprintf("; %q %v", cert.Subject.String(), expiry.DescribeValidity(cert, timeNow))
+			}
+		}
+		lines = append(lines, line)
+	}
 
-	if expiresInDays < 0 {
-		fmt.Printf("critical: cert for alias %q expired %v days ago\n", plugin.Alias, -expiresInDays)
-		return sensu.CheckStateCritical, nil
+	fmt.Printf("%v: keystore %v has %d aliases checked, closest to expiry is alias %q, %v\n", expiry.StateLabel(worst), plugin.Path, len(entries),
+		entries[closestAlias].alias, expiry.DescribeValidity(closestCerts[closestAlias], timeNow))
+	for _, line := range lines {
+		fmt.Println(line)
 	}
-	if timeNow.AddDate(0, 0, plugin.Critical).After(cert.NotAfter) {
-		fmt.Printf("critical: cert for alias %q expires in %v days\n", plugin.Alias, expiresInDays)
-		return sensu.CheckStateCritical, nil
+	return worst, nil
+}
+
+func executeCheck(event *corev2.Event) (int, error) {
+	expiryPolicy, err := expiry.NewPolicy(plugin.Warning, plugin.Critical, plugin.NotBeforeGrace)
+	if err != nil {
+		return sensu.CheckStateWarning, err
 	}
-	if timeNow.AddDate(0, 0, plugin.Warning).After(cert.NotAfter) {
-		fmt.Printf("warning: cert for alias %q expires in %v days\n", plugin.Alias, expiresInDays)
-		return sensu.CheckStateWarning, nil
+	if plugin.AllAliases {
+		return checkAllAliases(expiryPolicy)
 	}
-	fmt.Printf("ok: cert for alias %q expires in %v days\n", plugin.Alias, expiresInDays)
-	return sensu.CheckStateOK, nil
+
+	cert, err := getCertFromKeystore()
+	if err != nil {
+		return sensu.CheckStateCritical, err
+	}
+
+	timeNow := time.Now()
+	state, _ := expiryPolicy.State(cert, timeNow)
+	fmt.Printf("%v: cert for alias %q %v\n", expiry.StateLabel(state), plugin.Alias, expiry.DescribeValidity(cert, timeNow))
+	return state, nil
 }
@@ -1,10 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sensu/sensu-plugin-sdk/sensu"
+
+	"github.com/nmollerup/sensu-check-tls/internal/pkcs12"
 )
 
 // TestCheckArgs validates flag validation.
@@ -18,58 +33,115 @@ func TestCheckArgs(t *testing.T) {
 	}{
 		{
 			name:        "missing path",
-			config:      Config{Alias: "mycert", Password: "pass", Warning: 30, Critical: 7},
+			config:      Config{Alias: "mycert", Password: "pass", Warning: "30", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--path is required",
 		},
 		{
 			name:        "missing alias",
-			config:      Config{Path: "/etc/keystore.jks", Password: "pass", Warning: 30, Critical: 7},
+			config:      Config{Path: "/etc/keystore.jks", Password: "pass", Warning: "30", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--alias is required",
 		},
 		{
 			name:        "missing password",
-			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Warning: 30, Critical: 7},
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Warning: "30", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--password is required",
 		},
+		{
+			name:        "two password sources",
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", PasswordEnv: "KEYSTORE_PASS", Warning: "30", Critical: "7"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "only one of --password, --password-file and --password-env",
+		},
+		{
+			name:       "password file is valid",
+			config:     Config{Path: "/etc/keystore.jks", Alias: "mycert", PasswordFile: "/etc/keystore.pass", Warning: "30", Critical: "7"},
+			wantStatus: sensu.CheckStateOK,
+		},
 		{
 			name:        "missing critical",
-			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: 30},
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: "30"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--critical is required",
 		},
 		{
 			name:        "missing warning",
-			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Critical: 7},
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--warning is required",
 		},
 		{
 			name:        "warning less than critical",
-			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: 5, Critical: 7},
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: "5", Critical: "7"},
 			wantStatus:  sensu.CheckStateWarning,
 			wantErr:     true,
 			errContains: "--warning cannot be less than --critical",
 		},
 		{
 			name:       "valid config",
-			config:     Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: 30, Critical: 7},
+			config:     Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: "30", Critical: "7"},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
 		},
+		{
+			name:        "alias with all aliases",
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", AllAliases: true, Password: "pass", Warning: "30", Critical: "7"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--alias cannot be used with --all-aliases",
+		},
+		{
+			name:        "include without all aliases",
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", IncludeAlias: "^a", Password: "pass", Warning: "30", Critical: "7"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "require --all-aliases",
+		},
+		{
+			name:        "invalid exclude regex",
+			config:      Config{Path: "/etc/keystore.jks", AllAliases: true, ExcludeAlias: "(", Password: "pass", Warning: "30", Critical: "7"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--exclude-alias is not a valid regular expression",
+		},
+		{
+			name:       "all aliases without alias is valid",
+			config:     Config{Path: "/etc/keystore.jks", AllAliases: true, Password: "pass", Warning: "30", Critical: "7"},
+			wantStatus: sensu.CheckStateOK,
+		},
+		{
+			name:        "warning duration less than critical",
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: "12h", Critical: "36h"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--warning cannot be less than --critical",
+		},
+		{
+			name:       "percentage thresholds are valid",
+			config:     Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: "20%", Critical: "5%"},
+			wantStatus: sensu.CheckStateOK,
+		},
 		{
 			name:       "warning equals critical is valid",
-			config:     Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: 7, Critical: 7},
+			config:     Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: "7", Critical: "7"},
 			wantStatus: sensu.CheckStateOK,
 			wantErr:    false,
 		},
+		{
+			name:        "invalid not-before grace",
+			config:      Config{Path: "/etc/keystore.jks", Alias: "mycert", Password: "pass", Warning: "30", Critical: "7", NotBeforeGrace: "soon"},
+			wantStatus:  sensu.CheckStateWarning,
+			wantErr:     true,
+			errContains: "--not-before-grace must be a duration",
+		},
 	}
 
 	for _, tt := range tests {
@@ -92,56 +164,336 @@ func TestCheckArgs(t *testing.T) {
 	}
 }
 
-// TestExtractPEM verifies the PEM extraction helper used to parse keytool output.
-func TestExtractPEM(t *testing.T) {
-	validPEM := "-----BEGIN CERTIFICATE-----\nMIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA\n-----END CERTIFICATE-----"
+// TestParseJKS tests decoding JKS and JCEKS keystores and their integrity check.
+func TestParseJKS(t *testing.T) {
+	leaf := generateCert(t, "leaf.example.com", 90)
+	ca := generateCert(t, "Test CA", 365)
+	entries := []keystoreEntry{
+		{alias: "server", privateKey: true, chain: []*x509.Certificate{leaf, ca}},
+		{alias: "rootca", chain: []*x509.Certificate{ca}},
+	}
 
-	t.Run("extracts PEM block from keytool output", func(t *testing.T) {
-		keytoolOutput := "Your keystore contains 1 entry\n\n" + validPEM + "\n"
-		result := extractPEM(keytoolOutput)
-		if result == "" {
-			t.Error("extractPEM() returned empty string for valid keytool output")
+	for _, magic := range []uint32{jksMagic, jceksMagic} {
+		t.Run(fmt.Sprintf("magic %08X", magic), func(t *testing.T) {
+			data := buildKeystore(t, magic, "changeit", entries)
+			got, err := parseJKS(data, "changeit")
+			if err != nil {
+				t.Fatalf("parseJKS() unexpected error: %v", err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("parseJKS() returned %d entries, want 2", len(got))
+			}
+			if got[0].alias != "server" || !got[0].privateKey || len(got[0].chain) != 2 || !got[0].chain[0].Equal(leaf) {
+				t.Errorf("parseJKS() private key entry = %+v", got[0])
+			}
+			if got[1].alias != "rootca" || got[1].privateKey || len(got[1].chain) != 1 || !got[1].chain[0].Equal(ca) {
+				t.Errorf("parseJKS() trusted entry = %+v", got[1])
+			}
+		})
+	}
+
+	data := buildKeystore(t, jksMagic, "changeit", entries)
+	t.Run("wrong password", func(t *testing.T) {
+		if _, err := parseJKS(data, "wrong"); err == nil || !strings.Contains(err.Error(), "integrity check failed") {
+			t.Errorf("parseJKS() error = %v, want integrity failure", err)
 		}
-		if !strings.HasPrefix(result, "-----BEGIN CERTIFICATE-----") {
-			t.Errorf("extractPEM() result = %q, want it to start with BEGIN CERTIFICATE", result)
+	})
+	t.Run("tampered", func(t *testing.T) {
+		tampered := append([]byte(nil), data...)
+		tampered[20] ^= 0xff
+		if _, err := parseJKS(tampered, "changeit"); err == nil || !strings.Contains(err.Error(), "integrity check failed") {
+			t.Errorf("parseJKS() error = %v, want integrity failure", err)
 		}
-		if !strings.HasSuffix(result, "-----END CERTIFICATE-----") {
-			t.Errorf("extractPEM() result = %q, want it to end with END CERTIFICATE", result)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		if _, err := parseJKS(data[:10], "changeit"); err == nil {
+			t.Error("parseJKS() expected error for truncated keystore")
 		}
 	})
-
-	t.Run("returns empty string when no PEM block present", func(t *testing.T) {
-		result := extractPEM("no certificate here")
-		if result != "" {
-			t.Errorf("extractPEM() = %q, want empty string", result)
+	t.Run("not a keystore", func(t *testing.T) {
+		body := []byte("this is not a java keystore")
+		if _, err := parseJKS(append(body, keystoreDigest(body, "changeit")...), "changeit"); err == nil || !strings.Contains(err.Error(), "not a JKS or JCEKS keystore") {
+			t.Errorf("parseJKS() error = %v, want magic error", err)
 		}
 	})
+}
+
+// TestParseJKSSecretKey tests reading past JCEKS secret key entries, which
+// hold no certificate.
+func TestParseJKSSecretKey(t *testing.T) {
+	ca := generateCert(t, "Test CA", 365)
+	data := buildKeystore(t, jceksMagic, "changeit", []keystoreEntry{{alias: "rootca", chain: []*x509.Certificate{ca}}})
+	data = addSecretKeys(t, data, "changeit", "hmac", "aes")
+
+	got, err := parseJKS(data, "changeit")
+	if err != nil {
+		t.Fatalf("parseJKS() unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].alias != "rootca" || !got[0].chain[0].Equal(ca) {
+		t.Errorf("parseJKS() = %+v, want only the trusted entry", got)
+	}
 
-	t.Run("returns empty string when only BEGIN present", func(t *testing.T) {
-		result := extractPEM("-----BEGIN CERTIFICATE-----\ndata only")
-		if result != "" {
-			t.Errorf("extractPEM() = %q, want empty string (no END block)", result)
+	t.Run("corrupt secret key", func(t *testing.T) {
+		body := append([]byte(nil), data[:len(data)-sha1.Size]...)
+		body[12+4+2+len("hmac")+8+4] = 0x00 // TC_OBJECT of the first secret key
+		if _, err := parseJKS(append(body, keystoreDigest(body, "changeit")...), "changeit"); err == nil || !strings.Contains(err.Error(), `secret key of alias "hmac"`) {
+			t.Errorf("parseJKS() error = %v, want secret key error", err)
 		}
 	})
+}
+
+// TestParseJKSEntryCount tests that an entry count larger than the keystore
+// could hold is rejected before anything is allocated for it.
+func TestParseJKSEntryCount(t *testing.T) {
+	data := buildKeystore(t, jksMagic, "changeit", []keystoreEntry{{alias: "mycert", chain: []*x509.Certificate{generateCert(t, "mycert", 90)}}})
+	body := append([]byte(nil), data[:len(data)-sha1.Size]...)
+	binary.BigEndian.PutUint32(body[8:], 0xFFFFFFFF)
+	if _, err := parseJKS(append(body, keystoreDigest(body, "changeit")...), "changeit"); err == nil || !strings.Contains(err.Error(), "has room for at most") {
+		t.Errorf("parseJKS() error = %v, want entry count error", err)
+	}
+}
+
+// TestParsePKCS12 tests decoding PKCS#12 keystores written by OpenSSL, both
+// PBES2-protected and in the legacy format, and verifying their MAC.
+func TestParsePKCS12(t *testing.T) {
+	tests := []struct {
+		file      string
+		wantAlias string
+		wantChain []string
+	}{
+		{"pbes2.p12", "server", []string{"server.example.com", "Test Intermediate CA", "Test Root CA"}},
+		{"legacy.p12", "server", []string{"server.example.com", "Test Intermediate CA", "Test Root CA"}},
+		{"truststore.p12", "CN=Test Root CA", []string{"Test Root CA"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data := readTestdata(t, tt.file)
+			got, err := parsePKCS12(data, "changeit")
+			if err != nil {
+				t.Fatalf("parsePKCS12() unexpected error: %v", err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("parsePKCS12() returned %d entries, want 1: %+v", len(got), got)
+			}
+			if got[0].alias != tt.wantAlias || got[0].privateKey != (len(tt.wantChain) > 1) || len(got[0].chain) != len(tt.wantChain) {
+				t.Fatalf("parsePKCS12() entry = %+v", got[0])
+			}
+			for i, cn := range tt.wantChain {
+				if got[0].chain[i].Subject.CommonName != cn {
+					t.Errorf("parsePKCS12() chain[%d] = %q, want %q", i, got[0].chain[i].Subject.CommonName, cn)
+				}
+			}
+
+			if _, err := parsePKCS12(data, "wrong"); err == nil || !strings.Contains(err.Error(), "integrity check failed") {
+				t.Errorf("parsePKCS12() wrong password error = %v, want integrity failure", err)
+			}
+		})
+	}
 
-	t.Run("handles PEM-only input", func(t *testing.T) {
-		result := extractPEM(validPEM)
-		if result == "" {
-			t.Error("extractPEM() returned empty string for PEM-only input")
+	t.Run("not PKCS#12", func(t *testing.T) {
+		if _, err := parsePKCS12([]byte{0x30, 0x03, 0x02, 0x01, 0x03}, "changeit"); err == nil {
+			t.Error("parsePKCS12() expected error for malformed keystore")
 		}
 	})
 }
 
-// TestExecuteCheckWithoutKeytool verifies graceful failure when keytool is not found.
-func TestExecuteCheckWithoutKeytool(t *testing.T) {
-	// On systems without keytool, getCertFromKeystore should return an error.
-	// On systems with keytool, it will fail to open the nonexistent keystore.
+// TestPKCS12EntriesSharedIntermediate tests that keys issued by one
+// intermediate, which Java stores only once, each get the full chain.
+func TestPKCS12EntriesSharedIntermediate(t *testing.T) {
+	root, rootKey := issueCert(t, "Root CA", nil, nil)
+	intermediate, intermediateKey := issueCert(t, "Intermediate CA", root, rootKey)
+	tomcat, _ := issueCert(t, "tomcat.example.com", intermediate, intermediateKey)
+	kafka, _ := issueCert(t, "kafka.example.com", intermediate, intermediateKey)
+	other := generateCert(t, "Other CA", 365)
+
+	entries := pkcs12Entries([]pkcs12.Cert{
+		{Cert: tomcat, FriendlyName: "tomcat", LocalKeyID: "01"},
+		{Cert: intermediate},
+		{Cert: root},
+		{Cert: kafka, FriendlyName: "kafka", LocalKeyID: "02"},
+		{Cert: other, FriendlyName: "otherca"},
+	}, []pkcs12.Key{
+		{FriendlyName: "tomcat", LocalKeyID: "01"},
+		{FriendlyName: "kafka", LocalKeyID: "02"},
+	})
+
+	if len(entries) != 3 {
+		t.Fatalf("pkcs12Entries() returned %d entries, want 3: %+v", len(entries), entries)
+	}
+	for i, want := range []*x509.Certificate{tomcat, kafka} {
+		chain := entries[i].chain
+		if len(chain) != 3 || !chain[0].Equal(want) || !chain[1].Equal(intermediate) || !chain[2].Equal(root) {
+			t.Errorf("pkcs12Entries() alias %q has %d certs, want leaf, intermediate and root", entries[i].alias, len(chain))
+		}
+	}
+	if entries[2].alias != "otherca" || entries[2].privateKey {
+		t.Errorf("pkcs12Entries() trusted entry = %+v", entries[2])
+	}
+}
+
+// TestParseKeystore tests detecting the keystore type from its contents.
+func TestParseKeystore(t *testing.T) {
+	entries := []keystoreEntry{{alias: "mycert", chain: []*x509.Certificate{generateCert(t, "mycert", 90)}}}
+	tests := []struct {
+		name      string
+		data      []byte
+		wantAlias string
+		wantErr   string
+	}{
+		{"jks", buildKeystore(t, jksMagic, "changeit", entries), "mycert", ""},
+		{"jceks", buildKeystore(t, jceksMagic, "changeit", entries), "mycert", ""},
+		{"pkcs12", readTestdata(t, "truststore.p12"), "CN=Test Root CA", ""},
+		{"unknown", []byte("-----BEGIN CERTIFICATE-----"), "", "unknown keystore type"},
+		{"empty", nil, "", "unknown keystore type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeystore(tt.data, "changeit")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseKeystore() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKeystore() unexpected error: %v", err)
+			}
+			if len(got) != 1 || got[0].alias != tt.wantAlias {
+				t.Errorf("parseKeystore() = %+v", got)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckPasswordSources tests reading the password from a file or the environment.
+func TestExecuteCheckPasswordSources(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join("testdata", "pbes2.p12")
+	passwordFile := filepath.Join(dir, "keystore.pass")
+	if err := os.WriteFile(passwordFile, []byte("changeit\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_KEYSTORE_PASSWORD", "changeit")
+
+	tests := []struct {
+		name       string
+		config     Config
+		wantStatus int
+		wantErr    bool
+	}{
+		{"password file", Config{PasswordFile: passwordFile}, sensu.CheckStateOK, false},
+		{"missing password file", Config{PasswordFile: filepath.Join(dir, "missing")}, sensu.CheckStateCritical, true},
+		{"password env", Config{PasswordEnv: "TEST_KEYSTORE_PASSWORD"}, sensu.CheckStateOK, false},
+		{"unset password env", Config{PasswordEnv: "TEST_KEYSTORE_UNSET"}, sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = tt.config
+			plugin.Path, plugin.Alias, plugin.Warning, plugin.Critical = path, "server", "30", "7"
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheck tests the full check flow against keystore files.
+func TestExecuteCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.jks")
+	if err := os.WriteFile(path, buildKeystore(t, jksMagic, "changeit", []keystoreEntry{
+		{alias: "expiring", privateKey: true, chain: []*x509.Certificate{generateCert(t, "expiring.example.com", 5)}},
+		{alias: "soon", privateKey: true, chain: []*x509.Certificate{generateCert(t, "soon.example.com", 20)}},
+		{alias: "fine", chain: []*x509.Certificate{generateCert(t, "fine.example.com", 90)}},
+		{alias: "expired", chain: []*x509.Certificate{generateCert(t, "expired.example.com", -3)}},
+	}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		alias      string
+		password   string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"ok", "fine", "changeit", sensu.CheckStateOK, false},
+		{"alias lookup ignores case", "FINE", "changeit", sensu.CheckStateOK, false},
+		{"warning", "soon", "changeit", sensu.CheckStateWarning, false},
+		{"critical", "expiring", "changeit", sensu.CheckStateCritical, false},
+		{"expired", "expired", "changeit", sensu.CheckStateCritical, false},
+		{"unknown alias", "missing", "changeit", sensu.CheckStateCritical, true},
+		{"wrong password", "fine", "wrong", sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{Path: path, Alias: tt.alias, Password: tt.password, Warning: "30", Critical: "7"}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckAllAliases tests checking every alias with include/exclude filters.
+func TestExecuteCheckAllAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.jks")
+	if err := os.WriteFile(path, buildKeystore(t, jksMagic, "changeit", []keystoreEntry{
+		{alias: "tomcat", privateKey: true, chain: []*x509.Certificate{generateCert(t, "tomcat.example.com", 90), generateCert(t, "Intermediate", 5)}},
+		{alias: "kafka", privateKey: true, chain: []*x509.Certificate{generateCert(t, "kafka.example.com", 20)}},
+		{alias: "rootca", chain: []*x509.Certificate{generateCert(t, "Root CA", 900)}},
+		{alias: "oldca", chain: []*x509.Certificate{generateCert(t, "Old CA", -3)}},
+	}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		include    string
+		exclude    string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"all aliases", "", "", sensu.CheckStateCritical, false},
+		{"expiring intermediate in chain", "^tomcat$", "", sensu.CheckStateCritical, false},
+		{"exclude critical aliases", "", "^(tomcat|oldca)$", sensu.CheckStateWarning, false},
+		{"include trusted only", "ca$", "^oldca$", sensu.CheckStateOK, false},
+		{"nothing matches", "^nomatch$", "", sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{Path: path, AllAliases: true, IncludeAlias: tt.include, ExcludeAlias: tt.exclude, Password: "changeit", Warning: "30", Critical: "7"}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executeCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("executeCheck() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestExecuteCheckMissingKeystore verifies graceful failure when the keystore does not exist.
+func TestExecuteCheckMissingKeystore(t *testing.T) {
 	plugin = Config{
 		Path:     "/nonexistent/keystore.jks",
 		Alias:    "test",
 		Password: "password",
-		Warning:  30,
-		Critical: 7,
+		Warning:  "30",
+		Critical: "7",
 	}
 	status, err := executeCheck(nil)
 	if err == nil {
@@ -151,3 +503,195 @@ func TestExecuteCheckWithoutKeytool(t *testing.T) {
 		t.Errorf("status = %v, want Critical", status)
 	}
 }
+
+// generateCert creates a self-signed certificate for cn expiring in days.
+func generateCert(t *testing.T, cn string, days int) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().AddDate(0, 0, -100),
+		NotAfter:     time.Now().AddDate(0, 0, days).Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// issueCert creates a CA certificate for cn signed by issuer, or a
+// self-signed one when issuer is nil, and returns it with its key.
+func issueCert(t *testing.T, cn string, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().AddDate(0, 0, -1),
+		NotAfter:              time.Now().AddDate(0, 0, 365),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	if issuer == nil {
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// buildKeystore encodes entries as a version 2 JKS/JCEKS keystore protected by
+// password. Private key entries carry placeholder key bytes.
+func buildKeystore(t *testing.T, magic uint32, password string, entries []keystoreEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	write := func(v any) {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+	writeCert := func(cert *x509.Certificate) {
+		writeUTF("X.509")
+		write(uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+
+	write(magic)
+	write(uint32(2))
+	write(uint32(len(entries)))
+	for _, entry := range entries {
+		if entry.privateKey {
+			write(uint32(jksPrivateKeyTag))
+		} else {
+			write(uint32(jksTrustedCertTag))
+		}
+		writeUTF(entry.alias)
+		write(time.Now().UnixMilli())
+		if entry.privateKey {
+			key := []byte("encrypted private key")
+			write(uint32(len(key)))
+			buf.Write(key)
+			write(uint32(len(entry.chain)))
+			for _, cert := range entry.chain {
+				writeCert(cert)
+			}
+		} else {
+			writeCert(entry.chain[0])
+		}
+	}
+	body := buf.Bytes()
+	return append(body, keystoreDigest(body, password)...)
+}
+
+// addSecretKeys inserts a JCEKS secret key entry for each alias at the start
+// of keystore data and recomputes its digest. The entries are serialized the
+// way Java writes the SealedObjectForKeyProtector holding the key.
+func addSecretKeys(t *testing.T, data []byte, password string, aliases ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	write := func(v any) {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+	for _, alias := range aliases {
+		write(uint32(jksSecretKeyTag))
+		writeUTF(alias)
+		write(time.Now().UnixMilli())
+
+		write(uint16(javaStreamMagic))
+		write(uint16(javaStreamVersion))
+		write(byte(tcObject))
+		write(byte(tcClassDesc)) // handle 0
+		writeUTF("com.sun.crypto.provider.SealedObjectForKeyProtector")
+		write(int64(-3650226485480866989))
+		write(byte(0x02))
+		write(uint16(0))
+		write(byte(tcEndBlockData))
+		write(byte(tcClassDesc)) // handle 1
+		writeUTF("javax.crypto.SealedObject")
+		write(int64(4482838265551344752))
+		write(byte(0x02))
+		write(uint16(4))
+		write(byte('['))
+		writeUTF("encodedParams")
+		write(byte(tcString)) // handle 2
+		writeUTF("[B")
+		write(byte('['))
+		writeUTF("encryptedContent")
+		write(byte(tcReference))
+		write(uint32(javaBaseHandle + 2))
+		write(byte('L'))
+		writeUTF("paramsAlg")
+		write(byte(tcString)) // handle 3
+		writeUTF("Ljava/lang/String;")
+		write(byte('L'))
+		writeUTF("sealAlg")
+		write(byte(tcReference))
+		write(uint32(javaBaseHandle + 3))
+		write(byte(tcEndBlockData))
+		write(byte(tcNull))
+		// handle 4 is the object; its SealedObject fields follow.
+		write(byte(tcArray))
+		write(byte(tcClassDesc)) // handle 5
+		writeUTF("[B")
+		write(int64(-5984413125824719648))
+		write(byte(0x02))
+		write(uint16(0))
+		write(byte(tcEndBlockData))
+		write(byte(tcNull))
+		params := []byte{0x30, 0x0d, 0x04, 0x08, 1, 2, 3, 4, 5, 6, 7, 8, 0x02, 0x01, 0x14}
+		write(uint32(len(params))) // handle 6
+		buf.Write(params)
+		write(byte(tcArray))
+		write(byte(tcReference))
+		write(uint32(javaBaseHandle + 5))
+		write(uint32(48)) // handle 7
+		buf.Write(bytes.Repeat([]byte{0xA5}, 48))
+		write(byte(tcString)) // handle 8
+		writeUTF("PBEWithMD5AndTripleDES")
+		write(byte(tcReference))
+		write(uint32(javaBaseHandle + 8))
+	}
+
+	body := append([]byte(nil), data[:len(data)-sha1.Size]...)
+	binary.BigEndian.PutUint32(body[8:], binary.BigEndian.Uint32(body[8:])+uint32(len(aliases)))
+	body = append(body[:12], append(buf.Bytes(), body[12:]...)...)
+	return append(body, keystoreDigest(body, password)...)
+}
+
+// readTestdata returns the contents of a file in testdata.
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/nmollerup/sensu-check-tls/internal/pkcs12"
+)
+
+// parsePKCS12 decodes a PKCS#12 keystore and verifies its MAC with password.
+func parsePKCS12(data []byte, password string) ([]keystoreEntry, error) {
+	certs, keys, err := pkcs12.Decode(data, password)
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return nil, fmt.Errorf("keystore integrity check failed: wrong password or corrupted keystore")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12Entries(certs, keys), nil
+}
+
+// pkcs12Entries groups certificate bags into keystore entries the way Java
+// does: each private key with the certificate sharing its localKeyId and that
+// certificate's issuers, then every certificate in no chain as a trusted
+// entry. Java stores an intermediate shared by several keys only once, so each
+// chain is walked on its own.
+func pkcs12Entries(certs []pkcs12.Cert, keys []pkcs12.Key) []keystoreEntry {
+	var entries []keystoreEntry
+	inChain := make([]bool, len(certs))
+	for i, key := range keys {
+		leaf := -1
+		for j, c := range certs {
+			if key.LocalKeyID != "" && c.LocalKeyID == key.LocalKeyID {
+				leaf = j
+				break
+			}
+		}
+		if leaf < 0 {
+			continue
+		}
+		alias := key.FriendlyName
+		if alias == "" {
+			alias = certs[leaf].FriendlyName
+		}
+		if alias == "" {
+			alias = fmt.Sprintf("key %d", i+1)
+		}
+		entry := keystoreEntry{alias: alias, privateKey: true}
+		visited := make([]bool, len(certs))
+		for next := leaf; next >= 0; {
+			visited[next], inChain[next] = true, true
+			cert := certs[next].Cert
+			entry.chain = append(entry.chain, cert)
+			next = -1
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				break
+			}
+			for j, c := range certs {
+				if !visited[j] && bytes.Equal(c.Cert.RawSubject, cert.RawIssuer) {
+					next = j
+					break
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	for i, c := range certs {
+		if inChain[i] {
+			continue
+		}
+		alias := c.FriendlyName
+		if alias == "" {
+			alias = c.Cert.Subject.String()
+		}
+		entries = append(entries, keystoreEntry{alias: alias, chain: []*x509.Certificate{c.Cert}})
+	}
+	return entries
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Java object serialization stream constants, as used by JCEKS to store the
+// SealedObject holding a secret key.
+const (
+	javaStreamMagic   = 0xACED
+	javaStreamVersion = 5
+	javaBaseHandle    = 0x7E0000
+
+	tcNull           = 0x70
+	tcReference      = 0x71
+	tcClassDesc      = 0x72
+	tcObject         = 0x73
+	tcString         = 0x74
+	tcArray          = 0x75
+	tcEndBlockData   = 0x78
+	tcBlockData      = 0x77
+	tcBlockDataLong  = 0x7A
+	tcLongString     = 0x7C
+	tcProxyClassDesc = 0x7D
+
+	scWriteMethod    = 0x01
+	scExternalizable = 0x04
+	scBlockData      = 0x08
+)
+
+// javaClassDesc is the part of a serialized class description needed to find
+// the end of an instance: its fields and its superclass.
+type javaClassDesc struct {
+	name   string
+	flags  byte
+	fields []byte // type codes
+	super  *javaClassDesc
+}
+
+// javaStream skips over one serialized Java object. It only understands the
+// classes, strings and arrays a SealedObject is made of.
+type javaStream struct {
+	k       *keystoreReader
+	handles []any
+}
+
+// skipJavaObject reads past a serialized Java object written by a fresh
+// ObjectOutputStream, as JCEKS does for each secret key entry.
+func (k *keystoreReader) skipJavaObject() error {
+	var header struct{ Magic, Version uint16 }
+	if k.err == nil {
+		k.err = binary.Read(k.r, binary.BigEndian, &header)
+	}
+	if k.err != nil {
+		return k.err
+	}
+	if header.Magic != javaStreamMagic || header.Version != javaStreamVersion {
+		return fmt.Errorf("not a Java serialization stream")
+	}
+	s := &javaStream{k: k}
+	return s.content()
+}
+
+func (s *javaStream) byte() byte {
+	b := s.k.bytes(1)
+	if s.k.err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (s *javaStream) handle(h any) {
+	s.handles = append(s.handles, h)
+}
+
+func (s *javaStream) reference() (any, error) {
+	h := int(s.k.uint32()) - javaBaseHandle
+	if s.k.err != nil {
+		return nil, s.k.err
+	}
+	if h < 0 || h >= len(s.handles) {
+		return nil, fmt.Errorf("invalid serialization handle")
+	}
+	return s.handles[h], nil
+}
+
+// content reads one object, string, array, class description or null.
+func (s *javaStream) content() error {
+	return s.value(s.byte())
+}
+
+// value reads the element introduced by tag.
+func (s *javaStream) value(tag byte) error {
+	if s.k.err != nil {
+		return s.k.err
+	}
+	switch tag {
+	case tcNull:
+		return nil
+	case tcReference:
+		_, err := s.reference()
+		return err
+	case tcString:
+		s.handle(s.k.utf())
+		return s.k.err
+	case tcLongString:
+		n := s.k.int64()
+		if n < 0 || n > int64(s.k.r.Len()) {
+			return fmt.Errorf("invalid serialized string length")
+		}
+		s.handle(string(s.k.bytes(int(n))))
+		return s.k.err
+	case tcClassDesc, tcProxyClassDesc:
+		_, err := s.classDesc(tag)
+		return err
+	case tcArray:
+		return s.array()
+	case tcObject:
+		return s.object()
+	default:
+		return fmt.Errorf("unsupported serialization element %02X", tag)
+	}
+}
+
+// classDesc reads a class description, or a reference to or null in place of one.
+func (s *javaStream) classDesc(tag byte) (*javaClassDesc, error) {
+	switch tag {
+	case tcNull:
+		return nil, nil
+	case tcReference:
+		h, err := s.reference()
+		if err != nil {
+			return nil, err
+		}
+		desc, ok := h.(*javaClassDesc)
+		if !ok {
+			return nil, fmt.Errorf("serialization handle is not a class description")
+		}
+		return desc, nil
+	case tcProxyClassDesc:
+		return nil, fmt.Errorf("serialized proxy classes are not supported")
+	case tcClassDesc:
+	default:
+		return nil, fmt.Errorf("expected a serialized class description, got %02X", tag)
+	}
+
+	desc := &javaClassDesc{name: s.k.utf()}
+	s.k.int64() // serialVersionUID
+	s.handle(desc)
+	desc.flags = s.byte()
+	var count uint16
+	if s.k.err == nil {
+		s.k.err = binary.Read(s.k.r, binary.BigEndian, &count)
+	}
+	for i := 0; i < int(count) && s.k.err == nil; i++ {
+		typeCode := s.byte()
+		s.k.utf() // field name
+		if typeCode == '[' || typeCode == 'L' {
+			if err := s.content(); err != nil { // field class name
+				return nil, err
+			}
+		}
+		desc.fields = append(desc.fields, typeCode)
+	}
+	if err := s.annotation(); err != nil {
+		return nil, err
+	}
+	super, err := s.classDesc(s.byte())
+	if err != nil {
+		return nil, err
+	}
+	desc.super = super
+	return desc, s.k.err
+}
+
+// annotation skips block data and objects up to the closing TC_ENDBLOCKDATA.
+func (s *javaStream) annotation() error {
+	for s.k.err == nil {
+		switch tag := s.byte(); tag {
+		case tcEndBlockData:
+			return nil
+		case tcBlockData:
+			s.k.bytes(int(s.byte()))
+		case tcBlockDataLong:
+			s.k.bytes(int(s.k.uint32()))
+		default:
+			if err := s.value(tag); err != nil {
+				return err
+			}
+		}
+	}
+	return s.k.err
+}
+
+// array reads an array of primitives or objects.
+func (s *javaStream) array() error {
+	desc, err := s.classDesc(s.byte())
+	if err != nil {
+		return err
+	}
+	if desc == nil || len(desc.name) < 2 || desc.name[0] != '[' {
+		return fmt.Errorf("serialized array has no array class")
+	}
+	s.handle(nil)
+	length := int(s.k.uint32())
+	if s.k.err != nil {
+		return s.k.err
+	}
+	if size := primitiveSize(desc.name[1]); size > 0 {
+		if length < 0 || length > s.k.r.Len()/size {
+			return fmt.Errorf("invalid serialized array length")
+		}
+		s.k.bytes(length * size)
+		return s.k.err
+	}
+	for i := 0; i < length && s.k.err == nil; i++ {
+		if err := s.content(); err != nil {
+			return err
+		}
+	}
+	return s.k.err
+}
+
+// object reads an instance of a serializable class: the field values of each
+// class from the topmost superclass down, followed by whatever a custom
+// writeObject method wrote.
+func (s *javaStream) object() error {
+	desc, err := s.classDesc(s.byte())
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return fmt.Errorf("serialized object has no class")
+	}
+	s.handle(nil)
+	var hierarchy []*javaClassDesc
+	for d := desc; d != nil; d = d.super {
+		hierarchy = append([]*javaClassDesc{d}, hierarchy...)
+	}
+	for _, d := range hierarchy {
+		if d.flags&scExternalizable != 0 {
+			if d.flags&scBlockData == 0 {
+				return fmt.Errorf("serialized class %v uses the old externalizable format", d.name)
+			}
+			if err := s.annotation(); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, typeCode := range d.fields {
+			if size := primitiveSize(typeCode); size > 0 {
+				s.k.bytes(size)
+			} else if err := s.content(); err != nil {
+				return err
+			}
+		}
+		if d.flags&scWriteMethod != 0 {
+			if err := s.annotation(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.k.err
+}
+
+// primitiveSize is the encoded size of a primitive field type code, or 0 for
+// objects and arrays.
+func primitiveSize(typeCode byte) int {
+	switch typeCode {
+	case 'B', 'Z':
+		return 1
+	case 'C', 'S':
+		return 2
+	case 'I', 'F':
+		return 4
+	case 'J', 'D':
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Regenerates the PKCS#12 keystores used by the tests with OpenSSL 3. The
+# certificates are valid for 100 years so the tests do not age.
+set -e
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+openssl req -x509 -newkey rsa:2048 -nodes -keyout "$tmp/root.key" -out "$tmp/root.pem" \
+	-subj "/CN=Test Root CA" -days 36500 -addext basicConstraints=critical,CA:true
+openssl req -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -keyout "$tmp/intermediate.key" -out "$tmp/intermediate.csr" \
+	-subj "/CN=Test Intermediate CA"
+openssl x509 -req -in "$tmp/intermediate.csr" -CA "$tmp/root.pem" -CAkey "$tmp/root.key" -CAcreateserial \
+	-days 36500 -extfile /dev/stdin -out "$tmp/intermediate.pem" <<EXT
+basicConstraints=critical,CA:true
+EXT
+openssl req -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -keyout "$tmp/server.key" -out "$tmp/server.csr" \
+	-subj "/CN=server.example.com"
+openssl x509 -req -in "$tmp/server.csr" -CA "$tmp/intermediate.pem" -CAkey "$tmp/intermediate.key" -CAcreateserial \
+	-days 36500 -out "$tmp/server.pem"
+cat "$tmp/intermediate.pem" "$tmp/root.pem" > "$tmp/cas.pem"
+
+# OpenSSL 3 defaults: PBES2 (PBKDF2-HMAC-SHA256, AES-256-CBC), HMAC-SHA256 MAC.
+openssl pkcs12 -export -name server -inkey "$tmp/server.key" -in "$tmp/server.pem" -certfile "$tmp/cas.pem" \
+	-passout pass:changeit -out pbes2.p12
+# Pre-OpenSSL 3 and older Java: RC2-40 certificates, 3DES key, SHA-1 MAC.
+openssl pkcs12 -export -legacy -name server -inkey "$tmp/server.key" -in "$tmp/server.pem" -certfile "$tmp/cas.pem" \
+	-passout pass:changeit -out legacy.p12
+# A trust store holding only certificates; OpenSSL gives them no friendlyName.
+openssl pkcs12 -export -nokeys -in "$tmp/root.pem" -passout pass:changeit -out truststore.p12
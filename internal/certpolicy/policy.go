@@ -0,0 +1,117 @@
+// Package certpolicy enforces the key strength, signature algorithm and
+// validity period policy shared by the certificate checks.
+package certpolicy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nmollerup/sensu-check-tls/internal/expiry"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// ecdsaCurves are the curve names --ecdsa-curves accepts.
+var ecdsaCurves = []string{"P-224", "P-256", "P-384", "P-521"}
+
+// weakSignatures are the signature algorithms based on SHA-1 or MD5.
+var weakSignatures = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// Policy is the key strength and signature policy set by --min-rsa-bits,
+// --ecdsa-curves, --allow-weak-signatures and --max-validity.
+type Policy struct {
+	MinRSABits          int
+	ECDSACurves         []string
+	AllowWeakSignatures bool
+	MaxValidity         time.Duration
+}
+
+// New validates the policy options and returns the policy they describe.
+func New(minRSABits int, curves []string, allowWeakSignatures bool, maxValidity string) (Policy, error) {
+	if minRSABits < 0 {
+		return Policy{}, fmt.Errorf("--min-rsa-bits cannot be negative")
+	}
+	for _, curve := range curves {
+		if !slices.Contains(ecdsaCurves, strings.ToUpper(curve)) {
+			return Policy{}, fmt.Errorf("--ecdsa-curves: unknown curve %q (use %v)", curve, strings.Join(ecdsaCurves, ", "))
+		}
+	}
+	validity, err := parseMaxValidity(maxValidity)
+	if err != nil {
+		return Policy{}, err
+	}
+	return Policy{MinRSABits: minRSABits, ECDSACurves: curves, AllowWeakSignatures: allowWeakSignatures, MaxValidity: validity}, nil
+}
+
+// parseMaxValidity parses --max-validity as whole days or a Go duration. An
+// empty value or 0 disables the check.
+func parseMaxValidity(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if days, err := strconv.Atoi(value); err == nil && days >= 0 {
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return duration, nil
+	}
+	return 0, fmt.Errorf("--max-validity must be days or a duration such as 9552h")
+}
+
+// isSelfSignedRoot reports whether cert is a CA certificate issued by itself.
+// The signature is not checked, as crypto/x509 refuses to verify SHA-1 and MD5.
+func isSelfSignedRoot(cert *x509.Certificate) bool {
+	return cert.IsCA && bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		(len(cert.AuthorityKeyId) == 0 || bytes.Equal(cert.AuthorityKeyId, cert.SubjectKeyId))
+}
+
+// Violations lists every way cert breaks the policy. Weak signatures on
+// self-signed roots are allowed, as nothing relies on them, and the maximum
+// validity only applies to end-entity certificates.
+func (p Policy) Violations(cert *x509.Certificate) []string {
+	var violations []string
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < p.MinRSABits {
+			violations = append(violations, fmt.Sprintf("RSA key of %d bits is below --min-rsa-bits %d", bits, p.MinRSABits))
+		}
+	case *ecdsa.PublicKey:
+		name := key.Curve.Params().Name
+		if len(p.ECDSACurves) > 0 && !slices.ContainsFunc(p.ECDSACurves, func(curve string) bool { return strings.EqualFold(curve, name) }) {
+			violations = append(violations, fmt.Sprintf("ECDSA curve %v is not in --ecdsa-curves", name))
+		}
+	}
+	if weakSignatures[cert.SignatureAlgorithm] && !p.AllowWeakSignatures && !isSelfSignedRoot(cert) {
+		violations = append(violations, fmt.Sprintf("weak %v signature", cert.SignatureAlgorithm))
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); p.MaxValidity > 0 && !cert.IsCA && validity > p.MaxValidity {
+		violations = append(violations, fmt.Sprintf("valid for %v, more than --max-validity %v", expiry.FormatRemaining(validity), expiry.FormatRemaining(p.MaxValidity)))
+	}
+	return violations
+}
+
+// Check applies the policy to certs and returns a line for each violation
+// with the certificate's subject. Any violation is critical.
+func (p Policy) Check(certs []*x509.Certificate) (int, []string) {
+	state := sensu.CheckStateOK
+	var lines []string
+	for _, cert := range certs {
+		for _, violation := range p.Violations(cert) {
+			state = sensu.CheckStateCritical
+			lines = append(lines, fmt.Sprintf("critical: %q %v", cert.Subject.String(), violation))
+		}
+	}
+	return state, lines
+}
@@ -0,0 +1,149 @@
+package certpolicy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNew tests validating the policy options.
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		minRSABits  int
+		curves      []string
+		maxValidity string
+		wantErr     string
+	}{
+		{name: "defaults", minRSABits: 2048, curves: []string{"P-256", "p-384"}},
+		{name: "negative rsa bits", minRSABits: -1, wantErr: "--min-rsa-bits"},
+		{name: "unknown curve", curves: []string{"secp256k1"}, wantErr: "--ecdsa-curves"},
+		{name: "max validity days", maxValidity: "398"},
+		{name: "max validity duration", maxValidity: "9552h"},
+		{name: "invalid max validity", maxValidity: "a year", wantErr: "--max-validity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.minRSABits, tt.curves, false, tt.maxValidity)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("New() unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("New() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestViolations tests the key size, curve, signature and validity rules.
+func TestViolations(t *testing.T) {
+	now := time.Now()
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caTemplate(1, "Policy CA", now.AddDate(-1, 0, 0), now.AddDate(10, 0, 0))
+	caCert := signCert(t, ca, ca, rsa2048, rsa2048, x509.SHA256WithRSA)
+
+	tests := []struct {
+		name           string
+		template       *x509.Certificate
+		key            crypto.Signer
+		algorithm      x509.SignatureAlgorithm
+		selfSigned     bool
+		allowWeak      bool
+		maxValidity    string
+		wantViolations int
+	}{
+		{name: "rsa 2048", template: leafTemplate(2, "ok.example.com", now.AddDate(0, 3, 0)), key: rsa2048, algorithm: x509.SHA256WithRSA},
+		{name: "rsa 1024", template: leafTemplate(3, "weak.example.com", now.AddDate(0, 3, 0)), key: rsa1024, algorithm: x509.SHA256WithRSA, wantViolations: 1},
+		{name: "allowed curve", template: leafTemplate(4, "p256.example.com", now.AddDate(0, 3, 0)), key: p256, algorithm: x509.SHA256WithRSA},
+		{name: "curve not allowed", template: leafTemplate(5, "p224.example.com", now.AddDate(0, 3, 0)), key: p224, algorithm: x509.SHA256WithRSA, wantViolations: 1},
+		{name: "sha1 signature", template: leafTemplate(6, "sha1.example.com", now.AddDate(0, 3, 0)), key: rsa2048, algorithm: x509.SHA1WithRSA, wantViolations: 1},
+		{name: "sha1 allowed", template: leafTemplate(7, "sha1.example.com", now.AddDate(0, 3, 0)), key: rsa2048, algorithm: x509.SHA1WithRSA, allowWeak: true},
+		{name: "sha1 self-signed root", template: caTemplate(8, "Old Root", now.AddDate(-10, 0, 0), now.AddDate(10, 0, 0)), key: rsa2048, algorithm: x509.SHA1WithRSA, selfSigned: true},
+		{name: "longer than max validity", template: leafTemplate(9, "long.example.com", now.AddDate(2, 0, 0)), key: rsa2048, algorithm: x509.SHA256WithRSA, maxValidity: "398", wantViolations: 1},
+		{name: "within max validity", template: leafTemplate(10, "short.example.com", now.AddDate(0, 3, 0)), key: rsa2048, algorithm: x509.SHA256WithRSA, maxValidity: "398"},
+		{name: "max validity ignores CAs", template: caTemplate(11, "Long CA", now.AddDate(-1, 0, 0), now.AddDate(5, 0, 0)), key: p256, algorithm: x509.SHA256WithRSA, maxValidity: "398"},
+		{name: "several violations", template: leafTemplate(12, "bad.example.com", now.AddDate(3, 0, 0)), key: rsa1024, algorithm: x509.SHA1WithRSA, maxValidity: "9552h", wantViolations: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := New(2048, []string{"P-256", "P-384", "P-521"}, tt.allowWeak, tt.maxValidity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parent, parentKey := caCert, crypto.Signer(rsa2048)
+			if tt.selfSigned {
+				parent, parentKey = tt.template, tt.key
+			}
+			cert := signCert(t, tt.template, parent, tt.key, parentKey, tt.algorithm)
+			if got := policy.Violations(cert); len(got) != tt.wantViolations {
+				t.Errorf("Violations() = %q, want %d violations", got, tt.wantViolations)
+			}
+		})
+	}
+}
+
+// caTemplate returns a CA certificate template.
+func caTemplate(serial int64, cn string, notBefore, notAfter time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+// leafTemplate returns a server certificate template for dnsName.
+func leafTemplate(serial int64, dnsName string, notAfter time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{dnsName},
+	}
+}
+
+// signCert issues template for key's public key, signed by parentKey with algorithm.
+func signCert(t *testing.T, template, parent *x509.Certificate, key, parentKey crypto.Signer, algorithm x509.SignatureAlgorithm) *x509.Certificate {
+	t.Helper()
+	template.SignatureAlgorithm = algorithm
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,128 @@
+// Package certstate records the leaf certificate seen per target in a state
+// file, so a change between runs can be reported.
+package certstate
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nmollerup/sensu-check-tls/internal/pins"
+	"github.com/nmollerup/sensu-check-tls/internal/statefile"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// ChangeStates maps the --change-state names to check states.
+var ChangeStates = map[string]int{
+	"ok":       sensu.CheckStateOK,
+	"warning":  sensu.CheckStateWarning,
+	"critical": sensu.CheckStateCritical,
+}
+
+// Seen is what the state file records about a target's leaf certificate.
+type Seen struct {
+	Fingerprint string    `json:"fingerprint"`
+	Serial      string    `json:"serial"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+// SeenCerts records the leaf certificate last seen per target.
+type SeenCerts map[string]Seen
+
+// Load reads the state file at path. A missing file is an empty state.
+func Load(path string) (SeenCerts, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return SeenCerts{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %v", err)
+	}
+	seen := SeenCerts{}
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return nil, fmt.Errorf("cannot parse state file %v: %v", path, err)
+	}
+	return seen, nil
+}
+
+// Save replaces the state file at path. Callers sharing the file hold its
+// lock from Load to Save.
+func (s SeenCerts) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state file: %v", err)
+	}
+	if err := statefile.WriteFile(path, data); err != nil {
+		return fmt.Errorf("writing state file: %v", err)
+	}
+	return nil
+}
+
+// Changes records cert as the one seen for target and returns an "old -> new"
+// line for each field that differs from the previous run. The first run for a
+// target has nothing to compare against and reports no changes.
+func (s SeenCerts) Changes(target string, cert *x509.Certificate) []string {
+	current := Seen{
+		Fingerprint: pins.CertPin(cert),
+		Serial:      fmt.Sprintf("%X", cert.SerialNumber),
+		Issuer:      cert.Issuer.String(),
+		NotAfter:    cert.NotAfter.UTC(),
+	}
+	last, ok := s[target]
+	s[target] = current
+	if !ok {
+		return nil
+	}
+
+	var lines []string
+	if last.Fingerprint != current.Fingerprint {
+		lines = append(lines, fmt.Sprintf("fingerprint: %v -> %v", last.Fingerprint, current.Fingerprint))
+	}
+	if last.Serial != current.Serial {
+		lines = append(lines, fmt.Sprintf("serial: %v -> %v", last.Serial, current.Serial))
+	}
+	if last.Issuer != current.Issuer {
+		lines = append(lines, fmt.Sprintf("issuer: %q -> %q", last.Issuer, current.Issuer))
+	}
+	if !last.NotAfter.Equal(current.NotAfter) {
+		lines = append(lines, fmt.Sprintf("not after: %v -> %v", last.NotAfter.Format(time.RFC3339), current.NotAfter.Format(time.RFC3339)))
+	}
+	return lines
+}
+
+// Check compares the leaf with the one recorded for target in the state file
+// at path by the previous run and records it for the next. A change is
+// reported with the old and new values in the changeState state (one of
+// ChangeStates); it only alerts once, as the new certificate is what the next
+// run compares against. The state file is locked throughout, so checks
+// sharing it do not lose each other's targets. The returned lines report the
+// change or any problem with the state file.
+func Check(path, changeState, target string, cert *x509.Certificate) (int, []string) {
+	unlock, err := statefile.Lock(path)
+	if err != nil {
+		return sensu.CheckStateWarning, []string{fmt.Sprintf("warning: %v", err)}
+	}
+	defer unlock()
+	seen, err := Load(path)
+	if err != nil {
+		return sensu.CheckStateWarning, []string{fmt.Sprintf("warning: %v", err)}
+	}
+	state := sensu.CheckStateOK
+	var lines []string
+	if changes := seen.Changes(target, cert); len(changes) > 0 {
+		state = ChangeStates[changeState]
+		lines = append(lines, fmt.Sprintf("%v: %v leaf certificate changed since the last run", changeState, target))
+		lines = append(lines, changes...)
+	}
+	if err := seen.Save(path); err != nil {
+		lines = append(lines, fmt.Sprintf("warning: %v", err))
+		if state < sensu.CheckStateWarning {
+			state = sensu.CheckStateWarning
+		}
+	}
+	return state, lines
+}
@@ -0,0 +1,125 @@
+package certstate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmollerup/sensu-check-tls/internal/pins"
+)
+
+// TestSeenCerts tests change detection and the state file round trip.
+func TestSeenCerts(t *testing.T) {
+	now := time.Now()
+	ca, caKey := issueCert(t, 1, "State CA", now.AddDate(1, 0, 0), nil, nil)
+	otherCA, otherKey := issueCert(t, 2, "Other CA", now.AddDate(1, 0, 0), nil, nil)
+	first, _ := issueCert(t, 10, "state.example.com", now.AddDate(0, 3, 0), ca, caKey)
+	renewed, _ := issueCert(t, 11, "state.example.com", now.AddDate(0, 6, 0), ca, caKey)
+	swapped, _ := issueCert(t, 10, "state.example.com", now.AddDate(0, 3, 0), otherCA, otherKey)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	seen, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() missing file: unexpected error: %v", err)
+	}
+
+	steps := []struct {
+		name        string
+		cert        *x509.Certificate
+		wantChanges int
+	}{
+		{"first run", first, 0},
+		{"unchanged", first, 0},
+		{"renewed", renewed, 3},
+		{"unchanged after renewal", renewed, 0},
+		{"different issuer", swapped, 4},
+	}
+	for _, step := range steps {
+		if got := seen.Changes("example.com:443", step.cert); len(got) != step.wantChanges {
+			t.Errorf("%v: Changes() = %q, want %d changes", step.name, got, step.wantChanges)
+		}
+	}
+
+	if err := seen.Save(path); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if got := loaded["example.com:443"]; got.Fingerprint != pins.CertPin(swapped) || !got.NotAfter.Equal(swapped.NotAfter) {
+		t.Errorf("loaded state = %+v, want the swapped certificate", got)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() expected error for corrupt state file")
+	}
+}
+
+// TestCheckConcurrent tests that checks sharing a state file each keep their
+// target.
+func TestCheckConcurrent(t *testing.T) {
+	ca, caKey := issueCert(t, 1, "State CA", time.Now().AddDate(1, 0, 0), nil, nil)
+	cert, _ := issueCert(t, 10, "state.example.com", time.Now().AddDate(0, 3, 0), ca, caKey)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	const targets = 20
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Check(path, "warning", fmt.Sprintf("host%d.example.com:443", i), cert)
+		}()
+	}
+	wg.Wait()
+
+	seen, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if len(seen) != targets {
+		t.Errorf("state file records %d targets, want %d", len(seen), targets)
+	}
+}
+
+// issueCert creates a certificate for cn signed by issuer, or a self-signed
+// CA certificate when issuer is nil, and returns it with its key.
+func issueCert(t *testing.T, serial int64, cn string, notAfter time.Time, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	if issuer == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
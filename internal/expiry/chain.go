@@ -0,0 +1,67 @@
+package expiry
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// ChainReport is the expiry of every certificate in a chain.
+type ChainReport struct {
+	// State is the worst state in the chain.
+	State int
+	// Summary counts the certificates and names the one closest to expiry.
+	Summary string
+	// Lines has one line per certificate, led by its own state.
+	Lines []string
+}
+
+// CheckChain applies p to every certificate the server presented, plus any
+// certificates only found in the verified path, and reports the one closest
+// to expiry.
+func (p Policy) CheckChain(chain, pathOnly []*x509.Certificate, timeNow time.Time) ChainReport {
+	certs := append(append([]*x509.Certificate{}, chain...), pathOnly...)
+	position := func(i int) string {
+		if i < len(chain) {
+			return fmt.Sprintf("position %d", i)
+		}
+		return "trust store"
+	}
+
+	report := ChainReport{State: sensu.CheckStateOK, Lines: make([]string, len(certs))}
+	closest := 0
+	for i, cert := range certs {
+		state, _ := p.State(cert, timeNow)
+		if state > report.State {
+			report.State = state
+		}
+		if cert.NotAfter.Before(certs[closest].NotAfter) {
+			closest = i
+		}
+		report.Lines[i] = fmt.Sprintf("%v: %q (%v) %v", StateLabel(state), cert.Subject.String(), position(i), DescribeValidity(cert, timeNow))
+	}
+	report.Summary = fmt.Sprintf("%d certs, closest to expiry is %q (%v), %v", len(certs),
+		certs[closest].Subject.String(), position(closest), DescribeValidity(certs[closest], timeNow))
+	return report
+}
+
+// PathOnly returns the certificates of the verified path that the server did
+// not present itself, typically the trusted root.
+func PathOnly(chain, path []*x509.Certificate) []*x509.Certificate {
+	var extra []*x509.Certificate
+	for _, cert := range path {
+		presented := false
+		for _, c := range chain {
+			if c.Equal(cert) {
+				presented = true
+				break
+			}
+		}
+		if !presented {
+			extra = append(extra, cert)
+		}
+	}
+	return extra
+}
@@ -0,0 +1,68 @@
+package expiry
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// TestCheckChain tests that the worst state across the chain is reported,
+// along with the certificate closest to expiry.
+func TestCheckChain(t *testing.T) {
+	now := time.Now()
+	cert := func(cn string, days int) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn}, NotAfter: now.Add(time.Duration(days) * 24 * time.Hour)}
+	}
+
+	tests := []struct {
+		name        string
+		chain       []*x509.Certificate
+		pathOnly    []*x509.Certificate
+		wantStatus  int
+		wantClosest string
+	}{
+		{"all ok", []*x509.Certificate{cert("leaf", 90), cert("intermediate", 365)}, nil, sensu.CheckStateOK, `"CN=leaf" (position 0)`},
+		{"intermediate in warning", []*x509.Certificate{cert("leaf", 90), cert("intermediate", 20)}, nil, sensu.CheckStateWarning, `"CN=intermediate" (position 1)`},
+		{"expired intermediate with fresh leaf", []*x509.Certificate{cert("leaf", 90), cert("intermediate", -2)}, nil, sensu.CheckStateCritical, `"CN=intermediate" (position 1)`},
+		{"root from trust store in critical", []*x509.Certificate{cert("leaf", 90)}, []*x509.Certificate{cert("root", 3)}, sensu.CheckStateCritical, `"CN=root" (trust store)`},
+	}
+
+	policy, err := NewPolicy("30", "7", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := policy.CheckChain(tt.chain, tt.pathOnly, now)
+			if report.State != tt.wantStatus {
+				t.Errorf("CheckChain() state = %v, want %v", report.State, tt.wantStatus)
+			}
+			if !strings.Contains(report.Summary, "closest to expiry is "+tt.wantClosest) {
+				t.Errorf("CheckChain() summary = %q, want closest %v", report.Summary, tt.wantClosest)
+			}
+			if len(report.Lines) != len(tt.chain)+len(tt.pathOnly) {
+				t.Errorf("CheckChain() returned %d lines, want one per certificate", len(report.Lines))
+			}
+		})
+	}
+}
+
+// TestPathOnly tests picking the certificates of the verified path that the
+// server did not present.
+func TestPathOnly(t *testing.T) {
+	leaf := &x509.Certificate{Raw: []byte("leaf")}
+	intermediate := &x509.Certificate{Raw: []byte("intermediate")}
+	root := &x509.Certificate{Raw: []byte("root")}
+
+	got := PathOnly([]*x509.Certificate{leaf, intermediate}, []*x509.Certificate{leaf, intermediate, root})
+	if len(got) != 1 || got[0] != root {
+		t.Errorf("PathOnly() = %v, want only the root", got)
+	}
+	if got := PathOnly([]*x509.Certificate{leaf}, nil); len(got) != 0 {
+		t.Errorf("PathOnly() without a verified path = %v, want none", got)
+	}
+}
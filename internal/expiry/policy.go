@@ -0,0 +1,85 @@
+package expiry
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Policy is the expiry policy set by --warning, --critical and
+// --not-before-grace.
+type Policy struct {
+	Warning        Threshold
+	Critical       Threshold
+	NotBeforeGrace time.Duration
+}
+
+// NewPolicy parses the --warning, --critical and --not-before-grace values.
+func NewPolicy(warning, critical, notBeforeGrace string) (Policy, error) {
+	w, c, err := ParseThresholds(warning, critical)
+	if err != nil {
+		return Policy{}, err
+	}
+	var grace time.Duration
+	if notBeforeGrace != "" {
+		if grace, err = time.ParseDuration(notBeforeGrace); err != nil || grace < 0 {
+			return Policy{}, fmt.Errorf("--not-before-grace must be a duration such as 5m")
+		}
+	}
+	return Policy{Warning: w, Critical: c, NotBeforeGrace: grace}, nil
+}
+
+// State applies the warning and critical thresholds to cert and returns the
+// resulting state along with the time left until it expires. A cert that is
+// not valid yet is critical.
+func (p Policy) State(cert *x509.Certificate, timeNow time.Time) (int, time.Duration) {
+	expiresIn := cert.NotAfter.Sub(timeNow)
+	if p.NotYetValid(cert, timeNow) || expiresIn < 0 || expiresIn < p.Critical.Before(cert) {
+		return sensu.CheckStateCritical, expiresIn
+	}
+	if expiresIn < p.Warning.Before(cert) {
+		return sensu.CheckStateWarning, expiresIn
+	}
+	return sensu.CheckStateOK, expiresIn
+}
+
+// NotYetValid reports whether cert's NotBefore is further in the future than
+// the grace period allows.
+func (p Policy) NotYetValid(cert *x509.Certificate, timeNow time.Time) bool {
+	return cert.NotBefore.Sub(timeNow) > p.NotBeforeGrace
+}
+
+// DescribeExpiry describes the time left until (or since) expiry.
+func DescribeExpiry(expiresIn time.Duration) string {
+	if expiresIn < 0 {
+		return fmt.Sprintf("expired %v ago", FormatRemaining(-expiresIn))
+	}
+	return fmt.Sprintf("expires in %v", FormatRemaining(expiresIn))
+}
+
+// DescribeValidity describes when cert expires, led by how far in the future
+// its NotBefore is when it is not valid yet.
+func DescribeValidity(cert *x509.Certificate, timeNow time.Time) string {
+	description := DescribeExpiry(cert.NotAfter.Sub(timeNow))
+	if startsIn := cert.NotBefore.Sub(timeNow); startsIn > 0 {
+		return fmt.Sprintf("not valid until %v (%v from now), %v", cert.NotBefore.UTC().Format(time.RFC3339), FormatRemaining(startsIn), description)
+	}
+	return description
+}
+
+// StateLabel is the lower-case name of a check state that leads each line
+// of output.
+func StateLabel(state int) string {
+	switch state {
+	case sensu.CheckStateOK:
+		return "ok"
+	case sensu.CheckStateWarning:
+		return "warning"
+	case sensu.CheckStateCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
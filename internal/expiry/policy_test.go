@@ -0,0 +1,128 @@
+package expiry
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// TestPolicyState tests applying day, duration and percentage thresholds.
+func TestPolicyState(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		name       string
+		expiresIn  time.Duration
+		lifetime   time.Duration
+		warning    string
+		critical   string
+		wantStatus int
+	}{
+		{"expired", -5 * day, 0, "30", "7", sensu.CheckStateCritical},
+		{"within critical days", 3 * day, 0, "30", "7", sensu.CheckStateCritical},
+		{"within warning days", 15 * day, 0, "30", "7", sensu.CheckStateWarning},
+		{"days ok", 60 * day, 0, "30", "7", sensu.CheckStateOK},
+		{"hours under critical duration", 20 * time.Hour, 0, "36h", "24h", sensu.CheckStateCritical},
+		{"hours under warning duration", 30 * time.Hour, 0, "36h", "24h", sensu.CheckStateWarning},
+		{"hours ok", 40 * time.Hour, 0, "36h", "24h", sensu.CheckStateOK},
+		{"percent critical", 5 * day, 90 * day, "20%", "10%", sensu.CheckStateCritical},
+		{"percent warning", 15 * day, 90 * day, "20%", "10%", sensu.CheckStateWarning},
+		{"percent ok", 30 * day, 90 * day, "20%", "10%", sensu.CheckStateOK},
+		{"percent of a one day cert", 2 * time.Hour, day, "20%", "10%", sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.warning, tt.critical, "")
+			if err != nil {
+				t.Fatalf("NewPolicy() unexpected error: %v", err)
+			}
+			if tt.lifetime == 0 {
+				tt.lifetime = 400 * day
+			}
+			now := time.Now()
+			notAfter := now.Add(tt.expiresIn)
+			cert := &x509.Certificate{NotBefore: notAfter.Add(-tt.lifetime), NotAfter: notAfter}
+			status, expiresIn := policy.State(cert, now)
+			if status != tt.wantStatus {
+				t.Errorf("State() status = %v, want %v", status, tt.wantStatus)
+			}
+			if expiresIn != tt.expiresIn {
+				t.Errorf("State() expiresIn = %v, want %v", expiresIn, tt.expiresIn)
+			}
+		})
+	}
+}
+
+// TestPolicyNotYetValid tests that certificates whose NotBefore is in the
+// future are critical unless within the grace period.
+func TestPolicyNotYetValid(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		name       string
+		startsIn   time.Duration
+		grace      string
+		wantStatus int
+	}{
+		{"already valid", -day, "", sensu.CheckStateOK},
+		{"valid tomorrow", day, "", sensu.CheckStateCritical},
+		{"valid in a minute", time.Minute, "", sensu.CheckStateCritical},
+		{"within grace", time.Minute, "5m", sensu.CheckStateOK},
+		{"beyond grace", 10 * time.Minute, "5m", sensu.CheckStateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy("30", "7", tt.grace)
+			if err != nil {
+				t.Fatalf("NewPolicy() unexpected error: %v", err)
+			}
+			now := time.Now()
+			notBefore := now.Add(tt.startsIn)
+			cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(365 * day)}
+			if status, _ := policy.State(cert, now); status != tt.wantStatus {
+				t.Errorf("State() status = %v, want %v", status, tt.wantStatus)
+			}
+		})
+	}
+
+	for _, grace := range []string{"-5m", "soon"} {
+		if _, err := NewPolicy("30", "7", grace); err == nil {
+			t.Errorf("NewPolicy() expected error for --not-before-grace %q", grace)
+		}
+	}
+}
+
+// TestDescribeExpiry tests that short times are shown in hours and minutes.
+func TestDescribeExpiry(t *testing.T) {
+	tests := []struct {
+		expiresIn time.Duration
+		want      string
+	}{
+		{30 * 24 * time.Hour, "expires in 30 days"},
+		{50 * time.Hour, "expires in 2 days"},
+		{20*time.Hour + 15*time.Minute, "expires in 20h15m"},
+		{-3 * time.Hour, "expired 3h0m ago"},
+		{-10 * 24 * time.Hour, "expired 10 days ago"},
+	}
+	for _, tt := range tests {
+		if got := DescribeExpiry(tt.expiresIn); got != tt.want {
+			t.Errorf("DescribeExpiry(%v) = %q, want %q", tt.expiresIn, got, tt.want)
+		}
+	}
+}
+
+// TestDescribeValidity tests that a certificate not valid yet leads with its
+// start date.
+func TestDescribeValidity(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	valid := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(30 * 24 * time.Hour)}
+	if got := DescribeValidity(valid, now); got != "expires in 30 days" {
+		t.Errorf("DescribeValidity() = %q", got)
+	}
+	future := &x509.Certificate{NotBefore: now.Add(3 * time.Hour), NotAfter: now.Add(30 * 24 * time.Hour)}
+	if got, want := DescribeValidity(future, now), "not valid until 2030-01-01T03:00:00Z (3h0m from now), expires in 30 days"; got != want {
+		t.Errorf("DescribeValidity() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+// Package expiry applies the expiry thresholds shared by the certificate checks.
+package expiry
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold is an expiry threshold given as whole days, a Go duration such
+// as 36h, or a percentage of the certificate's validity period such as 20%.
+type Threshold struct {
+	duration time.Duration
+	percent  float64
+}
+
+// ParseThreshold parses the value of flag, which must be positive.
+func ParseThreshold(flag, value string) (Threshold, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Threshold{}, fmt.Errorf("%v is required", flag)
+	}
+	if number, ok := strings.CutSuffix(value, "%"); ok {
+		percent, err := strconv.ParseFloat(number, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return Threshold{}, fmt.Errorf("%v: percentage %q must be greater than 0%% and at most 100%%", flag, value)
+		}
+		return Threshold{percent: percent}, nil
+	}
+	if days, err := strconv.Atoi(value); err == nil {
+		if days <= 0 {
+			return Threshold{}, fmt.Errorf("%v must be greater than 0 days, got %q", flag, value)
+		}
+		return Threshold{duration: time.Duration(days) * 24 * time.Hour}, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return Threshold{}, fmt.Errorf("%v: %q is not a number of days, a duration such as 36h or a percentage such as 20%%", flag, value)
+	}
+	if duration <= 0 {
+		return Threshold{}, fmt.Errorf("%v must be a positive duration, got %q", flag, value)
+	}
+	return Threshold{duration: duration}, nil
+}
+
+// ParseThresholds parses the --warning and --critical values, which must be
+// of the same kind so that one can be checked to be larger than the other.
+func ParseThresholds(warning, critical string) (Threshold, Threshold, error) {
+	w, err := ParseThreshold("--warning", warning)
+	if err != nil {
+		return Threshold{}, Threshold{}, err
+	}
+	c, err := ParseThreshold("--critical", critical)
+	if err != nil {
+		return Threshold{}, Threshold{}, err
+	}
+	if w.isPercent() != c.isPercent() {
+		return Threshold{}, Threshold{}, fmt.Errorf("--warning and --critical must both be percentages or both be days or durations")
+	}
+	return w, c, nil
+}
+
+// isPercent reports whether t is a percentage of the validity period.
+func (t Threshold) isPercent() bool {
+	return t.percent > 0
+}
+
+// Before returns how long before cert expires the threshold is crossed.
+func (t Threshold) Before(cert *x509.Certificate) time.Duration {
+	if t.isPercent() {
+		return time.Duration(float64(cert.NotAfter.Sub(cert.NotBefore)) * t.percent / 100)
+	}
+	return t.duration
+}
+
+// Less reports whether t is crossed later than other. It panics when one is
+// a percentage and the other is not, as ParseThresholds never returns such a
+// pair.
+func (t Threshold) Less(other Threshold) bool {
+	if t.isPercent() != other.isPercent() {
+		panic("expiry: comparing a percentage threshold with a duration")
+	}
+	if t.isPercent() {
+		return t.percent < other.percent
+	}
+	return t.duration < other.duration
+}
+
+// FormatRemaining formats the time left until (or since) expiry as whole
+// days, or as hours and minutes under two days.
+func FormatRemaining(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%v days", int(d.Hours()/24))
+	}
+	s := d.Round(time.Minute).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	return s
+}
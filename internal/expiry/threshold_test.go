@@ -0,0 +1,72 @@
+package expiry
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseThreshold tests parsing days, durations and percentages.
+func TestParseThreshold(t *testing.T) {
+	now := time.Now()
+	cert := &x509.Certificate{NotBefore: now, NotAfter: now.Add(100 * 24 * time.Hour)}
+	tests := []struct {
+		value      string
+		wantBefore time.Duration
+		wantErr    bool
+	}{
+		{"30", 30 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"25%", 25 * 24 * time.Hour, false},
+		{"12.5%", 12*24*time.Hour + 12*time.Hour, false},
+		{"150%", 0, true},
+		{"%", 0, true},
+		{"soon", 0, true},
+		{"", 0, true},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"-36h", 0, true},
+		{"0s", 0, true},
+		{"0%", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseThreshold("--warning", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseThreshold(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "--warning") {
+				t.Errorf("ParseThreshold(%q) error = %q, want it to name the flag", tt.value, err)
+			}
+			if before := got.Before(cert); before != tt.wantBefore {
+				t.Errorf("ParseThreshold(%q).Before() = %v, want %v", tt.value, before, tt.wantBefore)
+			}
+		})
+	}
+}
+
+// TestParseThresholds tests that --warning and --critical must be of the same
+// kind, and that comparing thresholds of different kinds panics.
+func TestParseThresholds(t *testing.T) {
+	if _, _, err := ParseThresholds("20%", "10%"); err != nil {
+		t.Errorf("ParseThresholds() unexpected error: %v", err)
+	}
+	if _, _, err := ParseThresholds("30", "24h"); err != nil {
+		t.Errorf("ParseThresholds() unexpected error: %v", err)
+	}
+	if _, _, err := ParseThresholds("20%", "7"); err == nil {
+		t.Error("ParseThresholds() expected error for a percentage and days")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Less() expected a panic for a percentage and a duration")
+		}
+	}()
+	percent, _ := ParseThreshold("--warning", "20%")
+	days, _ := ParseThreshold("--critical", "7")
+	percent.Less(days)
+}
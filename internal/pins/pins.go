@@ -0,0 +1,109 @@
+// Package pins matches certificate and public key pins against a chain.
+package pins
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// Parse decodes a SHA-256 pin given as hex (colons allowed) or base64, with
+// an optional "sha256/" prefix as used for HPKP and mobile app pins.
+func Parse(pin string) ([]byte, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	if sum, err := hex.DecodeString(strings.ReplaceAll(value, ":", "")); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	return nil, fmt.Errorf("%q is not a SHA-256 pin in hex or base64", pin)
+}
+
+// ParseAll decodes the pins given to option.
+func ParseAll(option string, pins []string) ([][]byte, error) {
+	sums := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		sum, err := Parse(pin)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", option, err)
+		}
+		sums = append(sums, sum)
+	}
+	return sums, nil
+}
+
+// CertPin is the SHA-256 fingerprint of the whole certificate, formatted like
+// openssl x509 -fingerprint -sha256.
+func CertPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	pairs := make([]string, len(sum))
+	for i, b := range sum {
+		pairs[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(pairs, ":")
+}
+
+// SPKIPin is the base64 SHA-256 hash of the certificate's SubjectPublicKeyInfo.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// containsSum reports whether sums holds sum.
+func containsSum(sums [][]byte, sum []byte) bool {
+	for _, s := range sums {
+		if bytes.Equal(s, sum) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check looks for a certificate in the served chain, the leaf first, or only
+// in the verified path, whose fingerprint is in certPins (--pin-cert) or whose
+// public key hash is in spkiPins (--pin-spki). It is critical when none
+// matches, and then lists the actual pins of every certificate so the
+// expected ones can be updated after a planned rotation. The returned lines
+// report the match or the pins found.
+func Check(chain, pathOnly []*x509.Certificate, certPins, spkiPins []string, source string) (int, []string, error) {
+	certSums, err := ParseAll("--pin-cert", certPins)
+	if err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+	spkiSums, err := ParseAll("--pin-spki", spkiPins)
+	if err != nil {
+		return sensu.CheckStateCritical, nil, err
+	}
+
+	certs := append(append([]*x509.Certificate{}, chain...), pathOnly...)
+	position := func(i int) string {
+		if i < len(chain) {
+			return fmt.Sprintf("position %d", i)
+		}
+		return "trust store"
+	}
+
+	for i, cert := range certs {
+		certSum := sha256.Sum256(cert.Raw)
+		spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		switch {
+		case containsSum(certSums, certSum[:]):
+			return sensu.CheckStateOK, []string{fmt.Sprintf("ok: %v cert pin %v matches %q (%v)", source, CertPin(cert), cert.Subject.String(), position(i))}, nil
+		case containsSum(spkiSums, spkiSum[:]):
+			return sensu.CheckStateOK, []string{fmt.Sprintf("ok: %v SPKI pin %v matches %q (%v)", source, SPKIPin(cert), cert.Subject.String(), position(i))}, nil
+		}
+	}
+
+	lines := []string{fmt.Sprintf("critical: %v matches none of the %d expected pins", source, len(certSums)+len(spkiSums))}
+	for i, cert := range certs {
+		lines = append(lines, fmt.Sprintf("pins: %q (%v) cert %v spki %v", cert.Subject.String(), position(i), CertPin(cert), SPKIPin(cert)))
+	}
+	return sensu.CheckStateCritical, lines, nil
+}
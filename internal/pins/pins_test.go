@@ -0,0 +1,133 @@
+package pins
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// TestParse tests the accepted pin encodings.
+func TestParse(t *testing.T) {
+	sum := sha256.Sum256([]byte("pin"))
+	hexPin := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"hex", hexPin, false},
+		{"upper case hex with colons", colonHex(sum[:]), false},
+		{"base64", base64.StdEncoding.EncodeToString(sum[:]), false},
+		{"sha256 prefix", "sha256/" + base64.StdEncoding.EncodeToString(sum[:]), false},
+		{"sha1 length", hexPin[:40], true},
+		{"not a pin", "example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.pin)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.pin, err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != string(sum[:]) {
+				t.Errorf("Parse(%q) = %x, want %x", tt.pin, got, sum)
+			}
+		})
+	}
+}
+
+// TestCheck tests matching pins against the leaf, the chain and the trust store root.
+func TestCheck(t *testing.T) {
+	root, rootKey := issueCert(t, 1, "Pin Root", true, nil, nil)
+	intermediate, intKey := issueCert(t, 2, "Pin Intermediate", true, root, rootKey)
+	leaf, _ := issueCert(t, 3, "pin.example.com", false, intermediate, intKey)
+	other, _ := issueCert(t, 4, "other.example.com", false, intermediate, intKey)
+	chain := []*x509.Certificate{leaf, intermediate}
+
+	spkiHex := func(cert *x509.Certificate) string {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name       string
+		certPins   []string
+		spkiPins   []string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"leaf cert pin", []string{CertPin(leaf)}, nil, sensu.CheckStateOK, false},
+		{"leaf spki pin", nil, []string{SPKIPin(leaf)}, sensu.CheckStateOK, false},
+		{"intermediate spki pin in hex", nil, []string{spkiHex(intermediate)}, sensu.CheckStateOK, false},
+		{"root from trust store", nil, []string{SPKIPin(root)}, sensu.CheckStateOK, false},
+		{"one of several pins", []string{CertPin(other), strings.ToLower(CertPin(leaf))}, nil, sensu.CheckStateOK, false},
+		{"no pin matches", []string{CertPin(other)}, []string{SPKIPin(other)}, sensu.CheckStateCritical, false},
+		{"cert pin given as spki", nil, []string{CertPin(leaf)}, sensu.CheckStateCritical, false},
+		{"invalid pin", []string{"example.com"}, nil, sensu.CheckStateCritical, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, lines, err := Check(chain, []*x509.Certificate{root}, tt.certPins, tt.spkiPins, "test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("Check() status = %v, want %v", status, tt.wantStatus)
+			}
+			if !tt.wantErr && len(lines) == 0 {
+				t.Error("Check() returned no lines")
+			}
+		})
+	}
+}
+
+// issueCert creates a certificate for cn signed by issuer, or a self-signed
+// one when issuer is nil, and returns it with its key.
+func issueCert(t *testing.T, serial int64, cn string, isCA bool, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		BasicConstraintsValid: isCA,
+		IsCA:                  isCA,
+	}
+	if issuer == nil {
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// colonHex formats sum as upper case hex pairs separated by colons.
+func colonHex(sum []byte) string {
+	pairs := make([]string, len(sum))
+	for i, b := range sum {
+		pairs[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(pairs, ":")
+}
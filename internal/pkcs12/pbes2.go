@@ -0,0 +1,229 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1" //nolint:gosec // PKCS#12 MACs and PRFs may use SHA-1
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+// PKCS#5 (RFC 8018) object identifiers.
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// hashes maps the digest OIDs used by PKCS#12 MACs and the HMAC OIDs used as
+// PBKDF2 PRFs to their hash functions.
+var hashes = map[string]func() hash.Hash{
+	"1.3.14.3.2.26":          sha1.New,
+	"2.16.840.1.101.3.4.2.4": sha256.New224,
+	"2.16.840.1.101.3.4.2.1": sha256.New,
+	"2.16.840.1.101.3.4.2.2": sha512.New384,
+	"2.16.840.1.101.3.4.2.3": sha512.New,
+	"1.2.840.113549.2.7":     sha1.New,
+	"1.2.840.113549.2.8":     sha256.New224,
+	"1.2.840.113549.2.9":     sha256.New,
+	"1.2.840.113549.2.10":    sha512.New384,
+	"1.2.840.113549.2.11":    sha512.New,
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// IsEncryptedKey reports whether der is a PKCS#8 EncryptedPrivateKeyInfo
+// protected with PBES2.
+func IsEncryptedKey(der []byte) bool {
+	var info encryptedPrivateKeyInfo
+	_, err := asn1.Unmarshal(der, &info)
+	return err == nil && info.Algorithm.Algorithm.Equal(oidPBES2)
+}
+
+// DecryptKey decrypts a PBES2-protected PKCS#8 EncryptedPrivateKeyInfo and
+// returns the PKCS#8 PrivateKeyInfo.
+func DecryptKey(der []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("cannot parse encrypted private key: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption %v", info.Algorithm.Algorithm)
+	}
+	return DecryptPBES2(info.Algorithm, info.EncryptedData, password)
+}
+
+// DecryptPBES2 decrypts content protected with PBES2 (PBKDF2 with AES-CBC or
+// 3DES-CBC). A padding error, the sign of a wrong password, is
+// ErrIncorrectPassword.
+func DecryptPBES2(algorithm pkix.AlgorithmIdentifier, ciphertext []byte, password string) ([]byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("cannot parse PBES2 parameters: %v", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported PBES2 key derivation function %v", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("cannot parse PBKDF2 parameters: %v", err)
+	}
+	prf := kdf.PRF.Algorithm
+	if len(prf) == 0 {
+		prf = oidHMACWithSHA1
+	}
+	newHash, ok := hashes[prf.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF %v", prf)
+	}
+
+	var keyLength int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLength, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLength, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLength, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLength, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported PBES2 encryption scheme %v", scheme)
+	}
+
+	key, err := pbkdf2.Key(newHash, password, kdf.Salt, kdf.IterationCount, keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("deriving PBES2 key: %v", err)
+	}
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid PBES2 IV")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("PBES2 ciphertext is not a whole number of blocks")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrIncorrectPassword
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// verifyMAC checks the PKCS#12 MAC, keyed with the PKCS#12 KDF.
+func verifyMAC(md macData, content []byte, password string) error {
+	newHash, ok := hashes[md.Mac.Algorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported PKCS#12 MAC algorithm %v", md.Mac.Algorithm.Algorithm)
+	}
+	key := pkcs12KDF(newHash, md.MacSalt, bmpPassword(password), md.Iterations, 3, newHash().Size())
+	mac := hmac.New(newHash, key)
+	_, _ = mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), md.Mac.Digest) {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
+
+// pkcs12KDF derives size bytes of keying material as in RFC 7292 appendix B.2.
+func pkcs12KDF(newHash func() hash.Hash, salt, password []byte, iterations int, id byte, size int) []byte {
+	h := newHash()
+	u, v := h.Size(), h.BlockSize()
+
+	fill := func(src []byte) []byte {
+		if len(src) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(src)+v-1)/v))
+		for i := range out {
+			out[i] = src[i%len(src)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h.Reset()
+		_, _ = h.Write(d)
+		_, _ = h.Write(i)
+		a := h.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			h.Reset()
+			_, _ = h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for every v-byte block of I,
+		// where B is A repeated to v bytes.
+		b := make([]byte, v)
+		for k := range b {
+			b[k] = a[k%u]
+		}
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpPassword encodes password as a NUL-terminated big-endian UTF-16 string.
+func bmpPassword(password string) []byte {
+	var out []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		out = append(out, byte(c>>8), byte(c))
+	}
+	return append(out, 0, 0)
+}
+
+// decodeBMPString decodes a big-endian UTF-16 string.
+func decodeBMPString(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,291 @@
+// Package pkcs12 reads the certificates and keys in PKCS#12 files, both those
+// protected with PBES2 (PBKDF2 and AES), as written by current Java and
+// OpenSSL releases, and those using the legacy SHA-1/3DES/RC2 schemes.
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	legacy "golang.org/x/crypto/pkcs12"
+)
+
+// PKCS#12 (RFC 7292) object identifiers.
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+)
+
+// ErrIncorrectPassword reports a MAC or decryption failure: the password is
+// wrong or the data is corrupted.
+var ErrIncorrectPassword = errors.New("wrong password or corrupted data")
+
+// errLegacyPBE reports a PKCS#12 password-based encryption scheme that is
+// left to golang.org/x/crypto/pkcs12.
+var errLegacyPBE = errors.New("legacy PKCS#12 encryption")
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue `asn1:"tag:0,explicit"`
+	Attributes []attribute   `asn1:"set,optional"`
+}
+
+type attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// Cert is a certificate bag with the attributes that tie it to an alias and
+// to its private key.
+type Cert struct {
+	Cert         *x509.Certificate
+	FriendlyName string
+	LocalKeyID   string
+}
+
+// Key is a private key bag. The key itself is only decrypted by PrivateKey.
+type Key struct {
+	FriendlyName string
+	LocalKeyID   string
+	der          []byte // PKCS#8, PKCS#1 or SEC 1, or encrypted PKCS#8 when shrouded
+	shrouded     bool
+}
+
+// PrivateKey decrypts the key with password, when it is shrouded, and parses it.
+func (k Key) PrivateKey(password string) (crypto.PrivateKey, error) {
+	der := k.der
+	if k.shrouded {
+		var err error
+		if der, err = DecryptKey(der, password); err != nil {
+			return nil, err
+		}
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("cannot parse PKCS#12 private key")
+}
+
+// Decode returns every certificate and private key bag in a PKCS#12 file
+// after verifying its MAC with password. The localKeyId attributes are
+// hex-encoded.
+func Decode(data []byte, password string) ([]Cert, []Key, error) {
+	certs, keys, err := decode(data, password)
+	if errors.Is(err, errLegacyPBE) {
+		certs, keys, err = decodeLegacy(data, password)
+	}
+	return certs, keys, err
+}
+
+// DecodeLeaf decodes a PKCS#12 file and returns its first private key with
+// the certificate for it, found by localKeyId or else by public key, so
+// files that also carry the CA chain, in any order, are read too.
+func DecodeLeaf(data []byte, password string) (crypto.PrivateKey, *x509.Certificate, error) {
+	certs, keys, err := Decode(data, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no private key in PKCS#12 file")
+	}
+	key, err := keys[0].PrivateKey(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range certs {
+		if keys[0].LocalKeyID != "" && c.LocalKeyID == keys[0].LocalKeyID {
+			return key, c.Cert, nil
+		}
+	}
+	if signer, ok := key.(crypto.Signer); ok {
+		for _, c := range certs {
+			if public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && public.Equal(c.Cert.PublicKey) {
+				return key, c.Cert, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("no certificate for the private key in PKCS#12 file")
+}
+
+func decode(data []byte, password string) ([]Cert, []Key, error) {
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse PKCS#12 structure: %v", err)
+	} else if len(rest) > 0 {
+		return nil, nil, fmt.Errorf("trailing data after PKCS#12 structure")
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, nil, fmt.Errorf("PKCS#12 content is not plain data (public-key integrity mode is not supported)")
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse PKCS#12 content: %v", err)
+	}
+
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		if err := verifyMAC(pfx.MacData, authSafe, password); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var contents []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse PKCS#12 authenticated safe: %v", err)
+	}
+
+	var certs []Cert
+	var keys []Key
+	for _, ci := range contents {
+		var bagsData []byte
+		switch {
+		case ci.ContentType.Equal(oidDataContentType):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &bagsData); err != nil {
+				return nil, nil, fmt.Errorf("cannot parse PKCS#12 safe contents: %v", err)
+			}
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var ed encryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, nil, fmt.Errorf("cannot parse PKCS#12 encrypted data: %v", err)
+			}
+			algorithm := ed.EncryptedContentInfo.ContentEncryptionAlgorithm
+			if !algorithm.Algorithm.Equal(oidPBES2) {
+				return nil, nil, errLegacyPBE
+			}
+			var err error
+			if bagsData, err = DecryptPBES2(algorithm, ed.EncryptedContentInfo.EncryptedContent, password); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported PKCS#12 content type %v", ci.ContentType)
+		}
+
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(bagsData, &bags); err != nil {
+			return nil, nil, fmt.Errorf("cannot parse PKCS#12 safe bags: %v", err)
+		}
+		for _, bag := range bags {
+			friendlyName, localKeyID := bagAttributes(bag.Attributes)
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, fmt.Errorf("cannot parse PKCS#12 certificate bag: %v", err)
+				}
+				if !cb.ID.Equal(oidX509Certificate) {
+					continue
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, fmt.Errorf("cannot parse PKCS#12 certificate: %v", err)
+				}
+				certs = append(certs, Cert{Cert: cert, FriendlyName: friendlyName, LocalKeyID: localKeyID})
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag), bag.ID.Equal(oidKeyBag):
+				keys = append(keys, Key{FriendlyName: friendlyName, LocalKeyID: localKeyID, der: bag.Value.Bytes, shrouded: bag.ID.Equal(oidPKCS8ShroudedKeyBag)})
+			}
+		}
+	}
+	return certs, keys, nil
+}
+
+// decodeLegacy reads a file using the PKCS#12 PBE schemes through
+// golang.org/x/crypto/pkcs12, which also verifies the SHA-1 MAC.
+func decodeLegacy(data []byte, password string) ([]Cert, []Key, error) {
+	blocks, err := legacy.ToPEM(data, password)
+	if err != nil {
+		if errors.Is(err, legacy.ErrIncorrectPassword) {
+			return nil, nil, ErrIncorrectPassword
+		}
+		return nil, nil, fmt.Errorf("cannot decode PKCS#12: %v", err)
+	}
+	var certs []Cert
+	var keys []Key
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			keys = append(keys, Key{FriendlyName: block.Headers["friendlyName"], LocalKeyID: block.Headers["localKeyId"], der: block.Bytes})
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse PKCS#12 certificate: %v", err)
+		}
+		certs = append(certs, Cert{Cert: cert, FriendlyName: block.Headers["friendlyName"], LocalKeyID: block.Headers["localKeyId"]})
+	}
+	return certs, keys, nil
+}
+
+// bagAttributes returns the friendlyName and hex-encoded localKeyId of a bag.
+func bagAttributes(attributes []attribute) (string, string) {
+	var friendlyName, localKeyID string
+	for _, attr := range attributes {
+		switch {
+		case attr.ID.Equal(oidFriendlyName):
+			var raw asn1.RawValue
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, &raw); err == nil {
+				friendlyName = decodeBMPString(raw.Bytes)
+			}
+		case attr.ID.Equal(oidLocalKeyID):
+			var id []byte
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, &id); err == nil {
+				localKeyID = hex.EncodeToString(id)
+			}
+		}
+	}
+	return friendlyName, localKeyID
+}
@@ -0,0 +1,100 @@
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDecode tests reading OpenSSL-written PKCS#12 files with the default
+// PBES2 protection and with the legacy schemes.
+func TestDecode(t *testing.T) {
+	for _, name := range []string{"pbes2.p12", "legacy.p12"} {
+		t.Run(name, func(t *testing.T) {
+			data := readTestdata(t, name)
+			certs, keys, err := Decode(data, "changeit")
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			if len(certs) != 2 || len(keys) != 1 {
+				t.Fatalf("Decode() returned %d certs and %d keys, want 2 and 1", len(certs), len(keys))
+			}
+			leaf := certs[0]
+			if leaf.Cert.Subject.CommonName != "server.example.com" || leaf.FriendlyName != "server" {
+				t.Errorf("Decode() leaf = %q named %q", leaf.Cert.Subject.CommonName, leaf.FriendlyName)
+			}
+			if leaf.LocalKeyID == "" || keys[0].LocalKeyID != leaf.LocalKeyID || keys[0].FriendlyName != "server" {
+				t.Errorf("Decode() key = %+v, want it tied to the leaf %q", keys[0], leaf.LocalKeyID)
+			}
+			if certs[1].Cert.Subject.CommonName != "Test Root CA" || certs[1].LocalKeyID != "" {
+				t.Errorf("Decode() second cert = %q with localKeyId %q", certs[1].Cert.Subject.CommonName, certs[1].LocalKeyID)
+			}
+			if _, _, err := Decode(data, "wrong"); !errors.Is(err, ErrIncorrectPassword) {
+				t.Errorf("Decode() wrong password error = %v, want ErrIncorrectPassword", err)
+			}
+		})
+	}
+
+	if _, _, err := Decode([]byte{0x30, 0x03, 0x02, 0x01, 0x03}, "changeit"); err == nil {
+		t.Error("Decode() expected error for malformed data")
+	}
+}
+
+// TestDecodeLeaf tests picking the certificate that goes with the private key
+// out of files that also carry the root.
+func TestDecodeLeaf(t *testing.T) {
+	for _, name := range []string{"pbes2.p12", "legacy.p12"} {
+		t.Run(name, func(t *testing.T) {
+			key, cert, err := DecodeLeaf(readTestdata(t, name), "changeit")
+			if err != nil {
+				t.Fatalf("DecodeLeaf() unexpected error: %v", err)
+			}
+			if cert.Subject.CommonName != "server.example.com" {
+				t.Errorf("DecodeLeaf() cert = %q, want server.example.com", cert.Subject.CommonName)
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok || !signer.Public().(*ecdsa.PublicKey).Equal(cert.PublicKey) {
+				t.Errorf("DecodeLeaf() key %T does not match the certificate", key)
+			}
+		})
+	}
+}
+
+// TestDecryptKey tests decrypting OpenSSL-written PBES2 PKCS#8 keys.
+func TestDecryptKey(t *testing.T) {
+	for _, name := range []string{"key-aes.p8", "key-des3.p8"} {
+		t.Run(name, func(t *testing.T) {
+			data := readTestdata(t, name)
+			if !IsEncryptedKey(data) {
+				t.Fatal("IsEncryptedKey() = false, want true")
+			}
+			der, err := DecryptKey(data, "changeit")
+			if err != nil {
+				t.Fatalf("DecryptKey() unexpected error: %v", err)
+			}
+			if _, err := x509.ParsePKCS8PrivateKey(der); err != nil {
+				t.Errorf("DecryptKey() result is not a PKCS#8 key: %v", err)
+			}
+			if _, err := DecryptKey(data, "wrong"); !errors.Is(err, ErrIncorrectPassword) {
+				t.Errorf("DecryptKey() wrong password error = %v, want ErrIncorrectPassword", err)
+			}
+		})
+	}
+
+	if IsEncryptedKey(readTestdata(t, "pbes2.p12")) {
+		t.Error("IsEncryptedKey() = true for a PKCS#12 file")
+	}
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,24 @@
+#!/bin/sh
+# Regenerates the PKCS#12 files and encrypted keys used by the tests with
+# OpenSSL 3. The certificates are valid for 100 years so the tests do not age.
+set -e
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+openssl req -x509 -newkey rsa:2048 -nodes -keyout "$tmp/root.key" -out "$tmp/root.pem" \
+	-subj "/CN=Test Root CA" -days 36500 -addext basicConstraints=critical,CA:true
+openssl req -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -keyout "$tmp/server.key" -out "$tmp/server.csr" \
+	-subj "/CN=server.example.com"
+openssl x509 -req -in "$tmp/server.csr" -CA "$tmp/root.pem" -CAkey "$tmp/root.key" -CAcreateserial \
+	-days 36500 -out "$tmp/server.pem"
+
+# OpenSSL 3 defaults: PBES2 (PBKDF2-HMAC-SHA256, AES-256-CBC), HMAC-SHA256 MAC.
+openssl pkcs12 -export -name server -inkey "$tmp/server.key" -in "$tmp/server.pem" -certfile "$tmp/root.pem" \
+	-passout pass:changeit -out pbes2.p12
+# Pre-OpenSSL 3: RC2-40 certificates, 3DES key, SHA-1 MAC.
+openssl pkcs12 -export -legacy -name server -inkey "$tmp/server.key" -in "$tmp/server.pem" -certfile "$tmp/root.pem" \
+	-passout pass:changeit -out legacy.p12
+# Encrypted PKCS#8 keys with AES-256 (the default) and 3DES.
+openssl pkcs8 -topk8 -in "$tmp/server.key" -outform DER -passout pass:changeit -out key-aes.p8
+openssl pkcs8 -topk8 -v2 des3 -in "$tmp/server.key" -outform DER -passout pass:changeit -out key-des3.p8
@@ -0,0 +1,71 @@
+// Package statefile guards the state files kept between runs, so checks that
+// share one neither lose each other's updates nor read a half-written file.
+package statefile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// lockTimeout is how long Lock waits for another check to finish.
+	lockTimeout = 10 * time.Second
+	// lockStale is the age after which a lock file is taken to be left over
+	// from a check that was killed.
+	lockStale = 2 * time.Minute
+	// lockRetry is how often Lock tries again.
+	lockRetry = 50 * time.Millisecond
+)
+
+// Lock takes the lock for the state file at path, a lock file next to it
+// created exclusively, which works the same on every platform. It waits up to
+// lockTimeout for another holder and removes stale lock files. The returned
+// function releases the lock.
+func Lock(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("locking state file: %v", err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockStale {
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("locking state file: %v still held after %v", lockPath, lockTimeout)
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// WriteFile writes data to a temporary file next to path and renames it over
+// path, so readers see either the old state or the new one.
+func WriteFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
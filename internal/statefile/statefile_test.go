@@ -0,0 +1,94 @@
+package statefile
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLock tests that concurrent read-modify-write cycles under the lock do
+// not lose updates.
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := WriteFile(path, []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := Lock(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer unlock()
+			data, err := os.ReadFile(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			n, _ := strconv.Atoi(string(data))
+			if err := WriteFile(path, []byte(strconv.Itoa(n+1))); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != strconv.Itoa(workers) {
+		t.Errorf("state = %s after %d updates", data, workers)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the state file", len(entries))
+	}
+}
+
+// TestLockTimeout tests waiting for a held lock and breaking a stale one.
+func TestLockTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 200 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lock(path); err == nil {
+		t.Fatal("Lock() expected error while the lock is held")
+	}
+	unlock()
+
+	// A lock file left behind by a killed check.
+	if err := os.WriteFile(path+".lock", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStale)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = Lock(path)
+	if err != nil {
+		t.Fatalf("Lock() stale lock: unexpected error: %v", err)
+	}
+	unlock()
+}